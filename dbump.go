@@ -4,15 +4,73 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"sort"
+	"strings"
 	"time"
 )
 
 // ErrMigrationAlreadyLocked is returned only when migration lock is already hold.
 // This might be in a situation when previous dbump migration has not finished properly
-// or just someone already holds this lock. See Config.UseForce to force lock acquire.
+// or just someone already holds this lock. See Config.LockTimeout to wait for the lock
+// and Config.UseForce to force lock acquire.
 var ErrMigrationAlreadyLocked = errors.New("migration is locked already")
 
+// ChecksumError is returned when applied migrations were changed after they were applied.
+// Use ModeRepairChecksums to store new checksums after a deliberate change.
+type ChecksumError struct {
+	// Migrations that have a different checksum.
+	Migrations []*Migration
+}
+
+func (e *ChecksumError) Error() string {
+	names := make([]string, 0, len(e.Migrations))
+	for _, m := range e.Migrations {
+		names = append(names, m.Name)
+	}
+	return "checksum mismatch for applied migrations: " + strings.Join(names, ", ")
+}
+
+// DirtyError is returned when a database has a step that was started but not finished.
+// Database should be fixed by hand and then version set with ModeForceVersion.
+type DirtyError struct {
+	// Entry of the unfinished step.
+	Entry LogEntry
+}
+
+func (e *DirtyError) Error() string {
+	return fmt.Sprintf("database is dirty: migration %d (%s) %s was started but not finished",
+		e.Entry.MigrationID, e.Entry.Name, e.Entry.Direction)
+}
+
+// IrreversibleError is returned when a revert of an irreversible migration is required.
+// See Migration.Irreversible.
+type IrreversibleError struct {
+	ID   int    // ID of the migration.
+	Name string // Name of the migration.
+}
+
+func (e *IrreversibleError) Error() string {
+	return fmt.Sprintf("migration %d (%s) is irreversible", e.ID, e.Name)
+}
+
+// MigrationError is returned by Run when a step fails.
+type MigrationError struct {
+	ID        int       // ID of the migration.
+	Name      string    // Name of the migration.
+	Direction Direction // Direction of the failed step.
+	StepIndex int       // StepIndex in the run, starts from 0.
+	Query     string    // Query of the step, empty when Config.RedactQuery is set.
+	Statement string    // Statement that failed, see StatementError. Empty when Config.RedactQuery is set.
+	Err       error     // Err returned by Migrator.
+}
+
+func (e *MigrationError) Error() string {
+	return fmt.Sprintf("migration %d (%s) %s: %s", e.ID, e.Name, e.Direction, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error { return e.Err }
+
 // MigrationDelimiter separates apply and revert queries inside a migration step/file.
 // Const is exported to be used by https://github.com/cristalhq/dbumper tool.
 const MigrationDelimiter = `--- apply above / revert below ---`
@@ -25,6 +83,10 @@ type Config struct {
 	// Loader of migrations.
 	Loader Loader
 
+	// Middlewares to wrap the Migrator, first middleware is the outermost.
+	// See Intercept, Timing, ReadOnly and Recorder. Default is nil.
+	Middlewares []Middleware
+
 	// Mode of the migration.
 	// Default is zero ModeNotSet (zero value) which is an incorrect value.
 	// Set mode explicitly to show how migration should be done.
@@ -34,6 +96,42 @@ type Config struct {
 	// Must be greater than 0 for this two modes.
 	Num int
 
+	// Version is a target version for ModeApplyTo, ModeRevertTo, ModeBaseline or ModeForceVersion modes.
+	// Must be in range of the loaded migrations, 0 means revert all the migrations.
+	Version int
+
+	// Reason why version is forced, required for ModeForceVersion.
+	// Stored in the migrations log by Migrator.
+	Reason string
+
+	// BaselineForce allows ModeBaseline for a database that already has migrations log.
+	// Default is false.
+	BaselineForce bool
+
+	// SparseIDs allows gaps between migration IDs, like timestamps: 20221016120000_add_users.sql.
+	// IDs still must be unique and positive, migrations are ordered by ID.
+	// IDs are int, so on 32-bit platforms they must not exceed 2147483647 and timestamps do not fit.
+	// Default is false which means IDs must be 1, 2, 3 and so on.
+	SparseIDs bool
+
+	// AllowOutOfOrder applies migrations below the current version that were not applied yet.
+	// Such migrations do not change the version and are never reverted when they are not applied.
+	// Migrator must implement HistoryMigrator to track applied migrations. Default is false.
+	AllowOutOfOrder bool
+
+	// Labels expression to select migrations by Migration.Labels, like `dev`, `!prod` or `prod and (eu or us)`.
+	// Supports `and`, `or` (or comma), `not` (or `!`) and parentheses.
+	// Migrations without labels always match. Skipped migrations are neither applied nor reverted,
+	// steps with PhaseSkipped are stored instead, so the version is changed past them
+	// and such migrations are considered applied.
+	// Default is empty which means all migrations are run.
+	Labels string
+
+	// Vars to replace `${name}` placeholders in migration queries, see ExpandVars.
+	// Undefined variable is an error. Checksums are calculated for queries before replacement.
+	// Default is nil which means queries are not changed.
+	Vars map[string]string
+
 	// Timeout per migration step. Default is 0 which means no timeout.
 	// Only Migrator.DoStep method will be bounded with this timeout.
 	Timeout time.Duration
@@ -42,11 +140,25 @@ type Config struct {
 	// Default is false.
 	NoDatabaseLock bool
 
+	// LockTimeout to wait for the database lock when it's held by another migration.
+	// Default is 0 which means ErrMigrationAlreadyLocked is returned right away.
+	LockTimeout time.Duration
+
+	// LockRetryInterval is a pause before the first retry to get the database lock,
+	// doubled after each attempt but not more than 5 seconds. Used only with LockTimeout.
+	// Default is 0 which means 100 milliseconds.
+	LockRetryInterval time.Duration
+
 	// DisableTx will run every migration not in a transaction.
 	// This completely depends on a specific Migrator implementation
 	// because not every database supports transaction, so this option can be no-op for some databases.
 	DisableTx bool
 
+	// SingleTx runs all the steps in one transaction, so run is applied completely or not at all.
+	// Migrator must implement TxMigrator. Cannot be used with steps that disable transaction.
+	// Default is false which means a transaction per step.
+	SingleTx bool
+
 	// UseForce to get a lock on a database. MUST be used with the caution.
 	// Should be used when previous migration run didn't unlock the database,
 	// and this blocks subsequent runs.
@@ -57,20 +169,56 @@ type Config struct {
 	// Going down does revert-apply-revert of each migration.
 	ZigZag bool
 
+	// Logger for the migration process, also passed to Migrator via context (see Logger function).
+	// Default is nil which means nothing is logged.
+	Logger *slog.Logger
+
+	// RedactQuery to not include step query into MigrationError.
+	// Useful when queries contain sensitive data. Default is false.
+	RedactQuery bool
+
 	// BeforeStep function will be invoked right before the DoStep for each step.
 	// Default is nil and means no-op.
 	BeforeStep func(ctx context.Context, step Step)
 
-	// AfterStep function will be invoked right after the DoStep for each step.
+	// AfterStep function will be invoked right after the DoStep for each successful step.
+	// See OnStepDone to be notified about failed steps.
 	// Default is nil and means no-op.
 	AfterStep func(ctx context.Context, step Step)
 
+	// CheckStep function will be invoked before the BeforeStep for each step.
+	// Returned error prevents the step from running and is returned by Run.
+	// Default is nil and means no-op.
+	CheckStep func(ctx context.Context, step Step) error
+
+	// OnStepDone function will be invoked after the DoStep for each step, successful or not.
+	// Default is nil and means no-op.
+	OnStepDone func(ctx context.Context, step Step, result StepResult)
+
+	// OnLock function will be invoked after the database lock is acquired.
+	// Not invoked when NoDatabaseLock is set. Default is nil and means no-op.
+	OnLock func(ctx context.Context)
+
+	// OnUnlock function will be invoked after the database lock is released.
+	// Not invoked when NoDatabaseLock is set. Default is nil and means no-op.
+	OnUnlock func(ctx context.Context)
+
+	// OnVersion function will be invoked when the current version is detected,
+	// target is a version that will be reached after the run. Invoked by Run and Plan.
+	// Default is nil and means no-op.
+	OnVersion func(ctx context.Context, current, target int)
+
+	// OnFinish function will be invoked at the end of Run with the error that Run returns.
+	// Not invoked when config is incorrect. Default is nil and means no-op.
+	OnFinish func(ctx context.Context, err error)
+
 	_ struct{} // enforce explicit field names.
 }
 
 // Migrator represents database over which we will run migrations.
 type Migrator interface {
 	// LockDB to prevent running other migrators at the same time.
+	// Must not block, ErrMigrationAlreadyLocked should be returned when lock is held by someone else.
 	LockDB(ctx context.Context) error
 	// UnlockDB to allow running other migrators later.
 	UnlockDB(ctx context.Context) error
@@ -88,16 +236,127 @@ type Migrator interface {
 	Version(ctx context.Context) (version int, err error)
 
 	// DoStep runs the given query and sets a new version on success.
+	// Steps with PhaseSkipped have nothing to run, only the version is set.
 	DoStep(ctx context.Context, step Step) error
 }
 
+// HistoryMigrator is a Migrator that stores checksums of the applied migrations.
+// When Migrator implements this interface checksums are verified before any step.
+type HistoryMigrator interface {
+	Migrator
+
+	// History returns all the log entries ordered from the oldest to the newest.
+	History(ctx context.Context) ([]LogEntry, error)
+
+	// SetChecksum for the migration with the given version. Used only in ModeRepairChecksums.
+	SetChecksum(ctx context.Context, version int, checksum string) error
+}
+
+// TxMigrator is a Migrator that can run few steps in one transaction, see Config.SingleTx.
+type TxMigrator interface {
+	Migrator
+
+	// InTx calls fn with doStep that runs steps in one transaction, including records about steps.
+	// Transaction is committed only when fn returns nil.
+	InTx(ctx context.Context, fn func(ctx context.Context, doStep func(ctx context.Context, step Step) error) error) error
+}
+
+// VersionSetter is a Migrator that can set a version without running migrations.
+// Used by ModeBaseline and ModeForceVersion.
+type VersionSetter interface {
+	Migrator
+
+	// SetVersion stores the version with the reason, all the migrations up to the version
+	// are considered applied. Version must be returned by Migrator.Version after that.
+	SetVersion(ctx context.Context, version int, reason string) error
+}
+
+// DirtyMigrator is a Migrator that marks steps as started before running them
+// to detect steps that failed halfway (usually steps without a transaction).
+// Run refuses to proceed on a dirty database, see DirtyError.
+type DirtyMigrator interface {
+	Migrator
+
+	// Dirty returns the step that was started but not finished, nil when database is clean.
+	// Database becomes clean after the next finished step or VersionSetter.SetVersion.
+	Dirty(ctx context.Context) (*LogEntry, error)
+}
+
+// RepeatableMigrator is a Migrator that supports repeatable migrations, see Migration.Repeatable.
+// Steps of such migrations have PhaseRepeatable and the current version,
+// DoStep stores them like other steps, but HistoryMigrator.History must skip them.
+type RepeatableMigrator interface {
+	Migrator
+
+	// RepeatableChecksums returns checksums of the latest steps with PhaseRepeatable by migration name.
+	RepeatableChecksums(ctx context.Context) (map[string]string, error)
+}
+
+// LogEntry is a record stored by Migrator on each step.
+type LogEntry struct {
+	Version     int
+	MigrationID int // Zero for entries that were stored before migration ID was tracked.
+	Name        string
+	Direction   Direction
+	Phase       Phase
+	Checksum    string
+	Reason      string // Reason of the entry stored by VersionSetter, empty for steps.
+	CreatedAt   time.Time
+}
+
 // Step represents exact thing that is going to run.
 type Step struct {
 	Version   int
 	Query     string
 	DisableTx bool
+
+	// Name of the migration from which this step was created.
+	Name string
+	// Direction of the step, apply or revert.
+	Direction Direction
+	// Checksum of the applied migration, empty for revert.
+	Checksum string
+	// MigrationID from which this step was created.
+	// Might differ from Version when migration is applied out of order.
+	MigrationID int
+	// Phase of the run in which this step is done.
+	Phase Phase
+	// Func to run instead of Query when set. Migrator must provide an Executor for it.
+	Func MigrationFunc
+}
+
+// StepResult is passed to Config.OnStepDone.
+type StepResult struct {
+	Index    int           // Index of the step in the run, starts from 0.
+	Err      error         // Err returned by Migrator, nil on success.
+	Duration time.Duration // Duration of the DoStep.
 }
 
+// Phase of the migration step.
+type Phase string
+
+const (
+	// PhaseMain is for steps that are required to reach the target version.
+	PhaseMain Phase = "main"
+	// PhaseZigZag is for additional steps that verify migration, see Config.ZigZag.
+	PhaseZigZag Phase = "zigzag"
+	// PhaseRepeatable is for steps of repeatable migrations, they do not change the version.
+	// See Migration.Repeatable.
+	PhaseRepeatable Phase = "repeatable"
+	// PhaseSkipped is for steps of migrations skipped by Config.Labels
+	// and for reverts of migrations that were not applied, see Config.AllowOutOfOrder.
+	// Query and Func of such steps are empty, Migrator must only store the step in the log.
+	PhaseSkipped Phase = "skipped"
+)
+
+// Direction of the migration step.
+type Direction string
+
+const (
+	DirectionApply  Direction = "apply"
+	DirectionRevert Direction = "revert"
+)
+
 // Loader returns migrations to be applied on a database.
 type Loader interface {
 	Load() ([]*Migration, error)
@@ -105,10 +364,65 @@ type Loader interface {
 
 // Migration represents migration step that will be runned on a database.
 type Migration struct {
-	ID     int    // ID of the migration, unique, positive, starts from 1.
-	Name   string // Name of the migration.
-	Apply  string // Apply query.
-	Revert string // Revert query.
+	ID       int    // ID of the migration, unique, positive, starts from 1 (see Config.SparseIDs).
+	Name     string // Name of the migration.
+	Apply    string // Apply query.
+	Revert   string // Revert query.
+	Checksum string // Checksum of Apply and Revert, see Checksum function.
+
+	ApplyFunc  MigrationFunc // Apply function, when set it is used instead of Apply query.
+	RevertFunc MigrationFunc // Revert function, when set it is used instead of Revert query.
+
+	ApplyDirectives  Directives // Directives from the header of Apply query.
+	RevertDirectives Directives // Directives from the header of Revert query.
+
+	// Irreversible migration cannot be reverted, steps that require revert return IrreversibleError.
+	// Set by `-- dbump:irreversible` in the revert part.
+	Irreversible bool
+
+	// Labels of the migration, see Config.Labels.
+	// Set by `-- dbump:labels dev,test` in the apply part or by the file name like `0003_seed@dev,test.sql`.
+	Labels []string
+
+	// Repeatable migration has no ID and no revert, it is identified by Name.
+	// It is applied after versioned migrations when it is new or its Checksum is changed.
+	// Loaded from files like `R_views.sql`. Migrator must implement RepeatableMigrator.
+	Repeatable bool
+}
+
+// Directives change how a migration is run.
+// In SQL files they are set with `-- dbump:<directive>` comments
+// at the beginning of apply or revert part.
+type Directives struct {
+	// NoTransaction runs the step not in a transaction, like Config.DisableTx.
+	// Set by `-- dbump:no-transaction`.
+	NoTransaction bool
+
+	// Irreversible marks the migration as irreversible, see Migration.Irreversible.
+	// Set by `-- dbump:irreversible`, allowed only in the revert part.
+	Irreversible bool
+
+	// Labels of the migration, see Migration.Labels.
+	// Set by `-- dbump:labels dev,test`, allowed only in the apply part.
+	Labels []string
+}
+
+// MigrationFunc is a migration written in Go.
+// Executor is bound to the step transaction, unless the step has DisableTx.
+type MigrationFunc func(ctx context.Context, exec Executor) error
+
+// Executor runs queries for MigrationFunc. Provided by Migrator.
+type Executor interface {
+	Exec(ctx context.Context, query string, args ...interface{}) error
+	Query(ctx context.Context, query string, args ...interface{}) (Rows, error)
+}
+
+// Rows returned by Executor.Query.
+type Rows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+	Close() error
 }
 
 // MigratorMode to change migration flow.
@@ -122,46 +436,134 @@ const (
 	ModeRevertAll
 	ModeRedo
 	ModeDrop
+	ModeRepairChecksums
+	ModeApplyTo
+	ModeRevertTo
+	ModeBaseline
+	ModeForceVersion
+	ModeValidate
 	modeMaxPossible
 )
 
 // Run the Migrator with migration queries provided by the Loader.
 func Run(ctx context.Context, config Config) error {
+	m, err := newMig(config)
+	if err != nil {
+		return err
+	}
+	return m.run(ctx)
+}
+
+// PlanReport describes what Run will do with the same config, see Plan.
+type PlanReport struct {
+	// Steps that Run will execute, in order.
+	Steps []Step
+	// Repairs are migrations which checksums Run will store in ModeRepairChecksums.
+	Repairs []*Migration
+}
+
+// Plan returns what Run will do with the given config, Run checks are done as well.
+// Database lock is not taken and nothing is stored,
+// however Migrator.Init is called to be able to get the current version.
+func Plan(ctx context.Context, config Config) (*PlanReport, error) {
+	m, err := newMig(config)
+	if err != nil {
+		return nil, err
+	}
+	return m.plan(ctx)
+}
+
+func newMig(config Config) (*mig, error) {
 	switch {
-	case config.Migrator == nil:
-		return errors.New("migrator cannot be nil")
+	case config.Migrator == nil && config.Mode != ModeValidate:
+		return nil, errors.New("migrator cannot be nil")
 	case config.Loader == nil:
-		return errors.New("loader cannot be nil")
+		return nil, errors.New("loader cannot be nil")
 	case config.Mode == ModeNotSet:
-		return errors.New("mode not set")
+		return nil, errors.New("mode not set")
 	case config.Mode < 0 || config.Mode >= modeMaxPossible:
-		return fmt.Errorf("incorrect mode provided: %d", config.Mode)
+		return nil, fmt.Errorf("incorrect mode provided: %d", config.Mode)
 	case config.Num <= 0 && (config.Mode == ModeApplyN || config.Mode == ModeRevertN):
-		return fmt.Errorf("num must be greater than 0: %d", config.Num)
+		return nil, fmt.Errorf("num must be greater than 0: %d", config.Num)
+	case config.Version < 0 && (config.Mode == ModeApplyTo || config.Mode == ModeRevertTo):
+		return nil, fmt.Errorf("version must not be negative: %d", config.Version)
+	case config.Version <= 0 && config.Mode == ModeBaseline:
+		return nil, fmt.Errorf("version must be greater than 0: %d", config.Version)
+	case config.Version < 0 && config.Mode == ModeForceVersion:
+		return nil, fmt.Errorf("version must not be negative: %d", config.Version)
+	case config.Reason == "" && config.Mode == ModeForceVersion:
+		return nil, errors.New("reason must be set to force version")
+	case config.LockTimeout < 0:
+		return nil, fmt.Errorf("lock timeout must not be negative: %s", config.LockTimeout)
+	case config.SingleTx && config.DisableTx:
+		return nil, errors.New("single transaction cannot be used with disabled transaction")
 	}
 
+	if config.LockRetryInterval <= 0 {
+		config.LockRetryInterval = defaultLockRetryInterval
+	}
+
+	if config.Logger == nil {
+		config.Logger = discardLogger
+	}
 	if config.BeforeStep == nil {
 		config.BeforeStep = noopHook
 	}
 	if config.AfterStep == nil {
 		config.AfterStep = noopHook
 	}
+	if config.CheckStep == nil {
+		config.CheckStep = func(context.Context, Step) error { return nil }
+	}
+	if config.OnStepDone == nil {
+		config.OnStepDone = func(context.Context, Step, StepResult) {}
+	}
+	if config.OnLock == nil {
+		config.OnLock = func(context.Context) {}
+	}
+	if config.OnUnlock == nil {
+		config.OnUnlock = func(context.Context) {}
+	}
+	if config.OnVersion == nil {
+		config.OnVersion = func(context.Context, int, int) {}
+	}
+	if config.OnFinish == nil {
+		config.OnFinish = func(context.Context, error) {}
+	}
 
-	m := mig{
+	m := &mig{
 		Config:   config,
-		Migrator: config.Migrator,
+		Migrator: applyMiddlewares(config.Migrator, config.Middlewares),
 		Loader:   config.Loader,
 	}
-	return m.run(ctx)
+
+	if config.Labels != "" {
+		expr, err := parseLabelExpr(config.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("labels: %w", err)
+		}
+		m.labels = expr
+	}
+	return m, nil
 }
 
 type mig struct {
 	Config
 	Migrator
 	Loader
+
+	labels     labelExpr
+	repeatable []*Migration // repeatable migrations sorted by name, set by load.
 }
 
-func (m *mig) run(ctx context.Context) error {
+func (m *mig) run(ctx context.Context) (err error) {
+	ctx = withLogger(ctx, m.Logger)
+	defer func() { m.OnFinish(ctx, err) }()
+
+	if m.Mode == ModeValidate {
+		return validate(m.Loader, m.SparseIDs)
+	}
+
 	migrations, err := m.load()
 	if err != nil {
 		return fmt.Errorf("load: %w", err)
@@ -169,27 +571,143 @@ func (m *mig) run(ctx context.Context) error {
 	return m.runMigrations(ctx, migrations)
 }
 
+func (m *mig) plan(ctx context.Context) (*PlanReport, error) {
+	ctx = withLogger(ctx, m.Logger)
+
+	if m.Mode == ModeValidate {
+		if err := validate(m.Loader, m.SparseIDs); err != nil {
+			return nil, err
+		}
+		return &PlanReport{}, nil
+	}
+
+	ms, err := m.load()
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+
+	if err := m.Init(ctx); err != nil {
+		return nil, fmt.Errorf("init: %w", err)
+	}
+
+	if m.Mode == ModeBaseline || m.Mode == ModeForceVersion {
+		if _, _, err := m.versionSetter(ctx, ms); err != nil {
+			return nil, err
+		}
+		return &PlanReport{}, nil
+	}
+
+	if _, err := m.txMigrator(); err != nil {
+		return nil, err
+	}
+	steps, repairs, err := m.getSteps(ctx, ms)
+	if err != nil {
+		return nil, err
+	}
+	return &PlanReport{Steps: steps, Repairs: repairs}, nil
+}
+
 func (m *mig) load() ([]*Migration, error) {
 	ms, err := m.Load()
 	if err != nil {
 		return nil, err
 	}
 
+	ms, m.repeatable, err = splitRepeatable(ms)
+	if err != nil {
+		return nil, err
+	}
+	m.repeatable, err = m.expandVars(m.repeatable)
+	if err != nil {
+		return nil, err
+	}
+
 	sort.SliceStable(ms, func(i, j int) bool {
 		return ms[i].ID < ms[j].ID
 	})
 
+	if err := m.checkIDs(ms); err != nil {
+		return nil, err
+	}
+	return m.expandVars(ms)
+}
+
+// splitRepeatable migrations from versioned ones, repeatable are sorted by name.
+func splitRepeatable(ms []*Migration) (versioned, repeatable []*Migration, err error) {
+	names := map[string]bool{}
+	for _, mig := range ms {
+		if !mig.Repeatable {
+			versioned = append(versioned, mig)
+			continue
+		}
+
+		switch {
+		case mig.Name == "":
+			return nil, nil, errors.New("repeatable migration must have a name")
+		case names[mig.Name]:
+			return nil, nil, fmt.Errorf("duplicate repeatable migration: %s", mig.Name)
+		case mig.Revert != "" || mig.RevertFunc != nil:
+			return nil, nil, fmt.Errorf("repeatable migration cannot be reverted: %s", mig.Name)
+		}
+		names[mig.Name] = true
+		repeatable = append(repeatable, mig)
+	}
+
+	sort.Slice(repeatable, func(i, j int) bool {
+		return repeatable[i].Name < repeatable[j].Name
+	})
+	return versioned, repeatable, nil
+}
+
+func (m *mig) checkIDs(ms []*Migration) error {
+	if m.SparseIDs {
+		for i, m := range ms {
+			switch {
+			case m.ID <= 0:
+				return fmt.Errorf("migration number must be positive: %d (%s)", m.ID, m.Name)
+			case i > 0 && m.ID == ms[i-1].ID:
+				return fmt.Errorf("duplicate migration number: %d (%s)", m.ID, m.Name)
+			}
+		}
+		return nil
+	}
+
 	for i, m := range ms {
 		switch want := i + 1; {
 		case m.ID < want:
-			return nil, fmt.Errorf("duplicate migration number: %d (%s)", m.ID, m.Name)
+			return fmt.Errorf("duplicate migration number: %d (%s)", m.ID, m.Name)
 		case m.ID > want:
-			return nil, fmt.Errorf("missing migration number: %d (have %d)", want, m.ID)
+			return fmt.Errorf("missing migration number: %d (have %d)", want, m.ID)
 		default:
 			// pass
 		}
 	}
-	return ms, nil
+	return nil
+}
+
+// expandVars in queries of the migrations, see Config.Vars.
+// Loaded migrations are not changed, checksum is kept for the original queries.
+func (m *mig) expandVars(ms []*Migration) ([]*Migration, error) {
+	if m.Vars == nil {
+		return ms, nil
+	}
+
+	res := make([]*Migration, 0, len(ms))
+	for _, mig := range ms {
+		apply, err := ExpandVars(mig.Apply, m.Vars)
+		if err != nil {
+			return nil, fmt.Errorf("%s: apply: %w", mig.Name, err)
+		}
+		revert, err := ExpandVars(mig.Revert, m.Vars)
+		if err != nil {
+			return nil, fmt.Errorf("%s: revert: %w", mig.Name, err)
+		}
+
+		expanded := *mig
+		expanded.Apply, expanded.Revert = apply, revert
+		res = append(res, &expanded)
+	}
+	return res, nil
 }
 
 func (m *mig) runMigrations(ctx context.Context, ms []*Migration) (err error) {
@@ -211,7 +729,7 @@ func (m *mig) runMigrations(ctx context.Context, ms []*Migration) (err error) {
 	err = m.runMigrationsLocked(ctx, ms)
 
 	// drop all dbump data.
-	if m.Mode == ModeDrop {
+	if m.Mode == ModeDrop && err == nil {
 		err = m.Drop(ctx)
 	}
 	return err
@@ -219,98 +737,564 @@ func (m *mig) runMigrations(ctx context.Context, ms []*Migration) (err error) {
 
 func (m *mig) lockDB(ctx context.Context) error {
 	if m.Config.NoDatabaseLock {
+		m.Logger.DebugContext(ctx, "database lock is disabled")
 		return nil
 	}
 
-	if err := m.LockDB(ctx); err != nil {
+	forced := false
+	if err := m.waitLockDB(ctx); err != nil {
 		if !m.UseForce {
 			return fmt.Errorf("lock db: %w", err)
 		}
+		m.Logger.WarnContext(ctx, "database lock failed, forcing unlock", slog.Any("error", err))
+
 		if err := m.UnlockDB(ctx); err != nil {
 			return fmt.Errorf("force unlock db: %w", err)
 		}
 		if err := m.LockDB(ctx); err != nil {
 			return fmt.Errorf("force lock db: %w", err)
 		}
+		forced = true
 	}
+
+	m.Logger.InfoContext(ctx, "database locked", slog.Bool("forced", forced))
+	m.OnLock(ctx)
 	return nil
 }
 
+const (
+	defaultLockRetryInterval = 100 * time.Millisecond
+	maxLockRetryInterval     = 5 * time.Second
+)
+
+// waitLockDB retries to get the database lock until Config.LockTimeout passes.
+func (m *mig) waitLockDB(ctx context.Context) error {
+	deadline := time.Now().Add(m.LockTimeout)
+	wait := m.LockRetryInterval
+
+	for attempt := 1; ; attempt++ {
+		err := m.LockDB(ctx)
+		if !errors.Is(err, ErrMigrationAlreadyLocked) {
+			return err
+		}
+
+		left := time.Until(deadline)
+		if left <= 0 {
+			return err
+		}
+		if wait > left {
+			wait = left
+		}
+		m.Logger.DebugContext(ctx, "database is locked, waiting", slog.Int("attempt", attempt), slog.Duration("wait", wait))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if wait *= 2; wait > maxLockRetryInterval {
+			wait = maxLockRetryInterval
+		}
+	}
+}
+
 func (m *mig) unlockDB(ctx context.Context) error {
 	if m.Config.NoDatabaseLock {
 		return nil
 	}
-	return m.UnlockDB(ctx)
+	if err := m.UnlockDB(ctx); err != nil {
+		return err
+	}
+
+	m.Logger.InfoContext(ctx, "database unlocked")
+	m.OnUnlock(ctx)
+	return nil
 }
 
 func (m *mig) runMigrationsLocked(ctx context.Context, ms []*Migration) error {
-	curr, target, err := m.getCurrAndTargetVersions(ctx, len(ms))
+	if m.Mode == ModeBaseline || m.Mode == ModeForceVersion {
+		vs, reason, err := m.versionSetter(ctx, ms)
+		if err != nil {
+			return err
+		}
+		return m.setVersion(ctx, vs, reason)
+	}
+
+	tm, err := m.txMigrator()
+	if err != nil {
+		return err
+	}
+	steps, repairs, err := m.getSteps(ctx, ms)
 	if err != nil {
-		return fmt.Errorf("version get: %w", err)
+		return err
+	}
+	if err := m.repairChecksums(ctx, repairs); err != nil {
+		return err
 	}
 
-	steps := m.prepareSteps(curr, target, ms)
+	if tm == nil {
+		return m.runSteps(ctx, steps, m.DoStep)
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+	return tm.InTx(ctx, func(ctx context.Context, doStep func(ctx context.Context, step Step) error) error {
+		return m.runSteps(ctx, steps, doStep)
+	})
+}
+
+func (m *mig) runSteps(ctx context.Context, steps []Step, doStep func(ctx context.Context, step Step) error) error {
 	for i, step := range steps {
+		if err := m.CheckStep(ctx, step); err != nil {
+			return fmt.Errorf("check step %d (%s) %s: %w", step.MigrationID, step.Name, step.Direction, err)
+		}
+
 		m.BeforeStep(ctx, step)
+		m.Logger.InfoContext(ctx, "step started", stepAttrs(step)...)
+
+		start := time.Now()
+		err := m.step(ctx, step, doStep)
+		took := time.Since(start)
+
+		m.OnStepDone(ctx, step, StepResult{
+			Index:    i,
+			Err:      err,
+			Duration: took,
+		})
 
-		if err := m.step(ctx, step); err != nil {
-			return fmt.Errorf("migration %d: %w\n%s", i, err, step.Query)
+		if err != nil {
+			attrs := append(stepAttrs(step), slog.Duration("duration", took), slog.Any("error", err))
+			m.Logger.ErrorContext(ctx, "step failed", attrs...)
+			return m.newMigrationError(i, step, err)
 		}
+		m.Logger.InfoContext(ctx, "step finished", append(stepAttrs(step), slog.Duration("duration", took))...)
 
 		m.AfterStep(ctx, step)
 	}
 	return nil
 }
 
-func (m *mig) step(ctx context.Context, step Step) error {
+// txMigrator for Config.SingleTx, nil when a single transaction is not used.
+func (m *mig) txMigrator() (TxMigrator, error) {
+	if !m.SingleTx {
+		return nil, nil
+	}
+	tm, ok := asMigrator[TxMigrator](m.Migrator)
+	if !ok {
+		return nil, errors.New("migrator does not support single transaction")
+	}
+	return tm, nil
+}
+
+// versionSetter for ModeBaseline (database was migrated without dbump)
+// or ModeForceVersion (to repair a broken state), returns the reason to store.
+func (m *mig) versionSetter(ctx context.Context, ms []*Migration) (VersionSetter, string, error) {
+	vs, ok := asMigrator[VersionSetter](m.Migrator)
+	if !ok {
+		return nil, "", errors.New("migrator does not support setting version")
+	}
+	if _, ok := position(ms, m.Config.Version); !ok {
+		return nil, "", fmt.Errorf("version %d is not found in migrations", m.Config.Version)
+	}
+
+	if m.Mode != ModeBaseline {
+		return vs, m.Reason, nil
+	}
+	hasLog, err := m.hasLog(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if hasLog && !m.BaselineForce {
+		return nil, "", errors.New("database has migrations log already, use BaselineForce to baseline anyway")
+	}
+	return vs, "baseline", nil
+}
+
+func (m *mig) setVersion(ctx context.Context, vs VersionSetter, reason string) error {
+	if err := vs.SetVersion(ctx, m.Config.Version, reason); err != nil {
+		return fmt.Errorf("set version: %w", err)
+	}
+	m.Logger.WarnContext(ctx, "version is set", slog.Int("version", m.Config.Version), slog.String("reason", reason))
+	return nil
+}
+
+// hasLog reports whether a database has any migration log.
+// When Migrator does not implement HistoryMigrator only the current version is checked.
+func (m *mig) hasLog(ctx context.Context) (bool, error) {
+	if hm, ok := asMigrator[HistoryMigrator](m.Migrator); ok {
+		entries, err := hm.History(ctx)
+		if err != nil {
+			return false, fmt.Errorf("get history: %w", err)
+		}
+		return len(entries) != 0, nil
+	}
+
+	version, err := m.Migrator.Version(ctx)
+	if err != nil {
+		return false, fmt.Errorf("get version: %w", err)
+	}
+	return version != 0, nil
+}
+
+func (m *mig) newMigrationError(idx int, step Step, err error) *MigrationError {
+	e := &MigrationError{
+		ID:        step.MigrationID,
+		Name:      step.Name,
+		Direction: step.Direction,
+		StepIndex: idx,
+		Query:     step.Query,
+		Err:       err,
+	}
+
+	var errStmt *StatementError
+	if errors.As(err, &errStmt) {
+		e.Statement = errStmt.Statement
+	}
+
+	if m.RedactQuery {
+		e.Query, e.Statement = "", ""
+	}
+	return e
+}
+
+// getSteps returns steps to run and, in ModeRepairChecksums, migrations which checksums should be repaired.
+// Nothing is changed in a database.
+func (m *mig) getSteps(ctx context.Context, ms []*Migration) ([]Step, []*Migration, error) {
+	if err := m.checkDirty(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	curr, target, err := m.getCurrAndTargetVersions(ctx, ms)
+	if err != nil {
+		return nil, nil, fmt.Errorf("version get: %w", err)
+	}
+	currVersion, targetVersion := lastVersion(ms[:curr]), lastVersion(ms[:target])
+	m.Logger.InfoContext(ctx, "versions detected", slog.Int("current", currVersion), slog.Int("target", targetVersion))
+	m.OnVersion(ctx, currVersion, targetVersion)
+
+	applied, err := m.getApplied(ctx, ms)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	repairs, err := m.verifyChecksums(ms[:curr], applied)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	steps, err := m.prepareSteps(curr, target, ms)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.AllowOutOfOrder {
+		steps, err = m.addOutOfOrderSteps(steps, ms[:curr], applied)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	switch m.Mode {
+	case ModeApplyAll, ModeApplyN, ModeApplyTo:
+		steps, err = m.addRepeatableSteps(ctx, steps, currVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if m.SingleTx {
+		for _, step := range steps {
+			if step.DisableTx {
+				return nil, nil, fmt.Errorf("migration %d (%s) %s disables transaction and cannot run in a single transaction",
+					step.MigrationID, step.Name, step.Direction)
+			}
+		}
+	}
+	return steps, repairs, nil
+}
+
+// checkDirty returns DirtyError if Migrator supports this and database is dirty.
+func (m *mig) checkDirty(ctx context.Context) error {
+	dm, ok := asMigrator[DirtyMigrator](m.Migrator)
+	if !ok {
+		return nil
+	}
+
+	entry, err := dm.Dirty(ctx)
+	switch {
+	case err != nil:
+		return fmt.Errorf("get dirty: %w", err)
+	case entry != nil:
+		return &DirtyError{Entry: *entry}
+	default:
+		return nil
+	}
+}
+
+// getApplied returns log entries of the applied migrations.
+// Returns nil map when Migrator does not implement HistoryMigrator.
+func (m *mig) getApplied(ctx context.Context, ms []*Migration) (map[int]LogEntry, error) {
+	hm, ok := asMigrator[HistoryMigrator](m.Migrator)
+	if !ok {
+		switch {
+		case m.Mode == ModeRepairChecksums:
+			return nil, errors.New("migrator does not support checksums")
+		case m.AllowOutOfOrder:
+			return nil, errors.New("migrator does not support out of order migrations")
+		}
+		return nil, nil
+	}
+
+	entries, err := hm.History(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get history: %w", err)
+	}
+	return appliedEntries(entries, ms), nil
+}
+
+// verifyChecksums of the applied migrations, returns ChecksumError on mismatch.
+// In ModeRepairChecksums migrations with a different checksum are returned instead.
+func (m *mig) verifyChecksums(ms []*Migration, applied map[int]LogEntry) ([]*Migration, error) {
+	var mismatched, repairs []*Migration
+	for _, mig := range ms {
+		entry, ok := applied[mig.ID]
+		if !ok {
+			continue
+		}
+		stored := entry.Checksum
+
+		switch {
+		case m.Mode == ModeRepairChecksums:
+			if stored != mig.Checksum {
+				repairs = append(repairs, mig)
+			}
+		case stored != "" && mig.Checksum != "" && stored != mig.Checksum:
+			mismatched = append(mismatched, mig)
+		}
+	}
+
+	if len(mismatched) != 0 {
+		return nil, &ChecksumError{Migrations: mismatched}
+	}
+	return repairs, nil
+}
+
+// repairChecksums stores checksums of the loaded migrations, see ModeRepairChecksums.
+func (m *mig) repairChecksums(ctx context.Context, ms []*Migration) error {
+	if len(ms) == 0 {
+		return nil
+	}
+	hm, ok := asMigrator[HistoryMigrator](m.Migrator)
+	if !ok {
+		return errors.New("migrator does not support checksums")
+	}
+
+	for _, mig := range ms {
+		if err := hm.SetChecksum(ctx, mig.ID, mig.Checksum); err != nil {
+			return fmt.Errorf("set checksum %d: %w", mig.ID, err)
+		}
+	}
+	return nil
+}
+
+// addOutOfOrderSteps to apply migrations below the current version that are not applied yet.
+// Version is not changed by such steps. In other modes these migrations are not reverted,
+// skipped steps are done instead.
+func (m *mig) addOutOfOrderSteps(steps []Step, ms []*Migration, applied map[int]LogEntry) ([]Step, error) {
+	missing := map[int]bool{}
+	version := lastVersion(ms)
+
+	isApply := false
+	switch m.Mode {
+	case ModeApplyAll, ModeApplyN, ModeApplyTo:
+		isApply = true
+	}
+
+	var res []Step
+	for i, mig := range ms {
+		if _, ok := applied[mig.ID]; ok {
+			continue
+		}
+		missing[mig.ID] = true
+
+		if !isApply || m.skipped(mig) {
+			continue
+		}
+
+		prev := lastVersion(ms[:i])
+		apply := mig.toStep(true, prev, PhaseMain, m.DisableTx)
+		apply.Version = version
+		res = append(res, apply)
+
+		if m.ZigZag {
+			if mig.Irreversible {
+				return nil, &IrreversibleError{ID: mig.ID, Name: mig.Name}
+			}
+			revert := mig.toStep(false, prev, PhaseZigZag, m.DisableTx)
+			revert.Version = version
+			apply.Phase = PhaseZigZag
+			res = append(res, revert, apply)
+		}
+	}
+
+	if isApply {
+		return append(res, steps...), nil
+	}
+
+	// not applied migrations are passed by skipped steps, so the version is still changed.
+	filtered := make([]Step, 0, len(steps))
+	for _, step := range steps {
+		switch {
+		case !missing[step.MigrationID], step.Phase == PhaseSkipped:
+			filtered = append(filtered, step)
+		case step.Phase == PhaseMain:
+			step.Phase = PhaseSkipped
+			step.Query, step.Func, step.DisableTx = "", nil, false
+			filtered = append(filtered, step)
+		}
+	}
+	return filtered, nil
+}
+
+// addRepeatableSteps to apply new and changed repeatable migrations after other steps.
+// Such steps keep the version of the last step, or the current version when there are no steps.
+func (m *mig) addRepeatableSteps(ctx context.Context, steps []Step, version int) ([]Step, error) {
+	if len(m.repeatable) == 0 {
+		return steps, nil
+	}
+
+	rm, ok := asMigrator[RepeatableMigrator](m.Migrator)
+	if !ok {
+		return nil, errors.New("migrator does not support repeatable migrations")
+	}
+	checksums, err := rm.RepeatableChecksums(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get repeatable checksums: %w", err)
+	}
+
+	if len(steps) != 0 {
+		version = steps[len(steps)-1].Version
+	}
+
+	for _, mig := range m.repeatable {
+		if m.skipped(mig) {
+			continue
+		}
+		if checksum, ok := checksums[mig.Name]; ok && checksum == mig.Checksum {
+			continue
+		}
+		step := mig.toStep(true, version, PhaseRepeatable, m.DisableTx)
+		step.Version = version
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// appliedEntries returns log entries of the applied migrations by replaying the log.
+// Migrations are used to mark everything up to the version as applied for entries without migration ID.
+func appliedEntries(entries []LogEntry, ms []*Migration) map[int]LogEntry {
+	applied := map[int]LogEntry{}
+	prev := 0
+	for _, e := range entries {
+		switch {
+		case e.MigrationID != 0 && e.Direction == DirectionApply:
+			applied[e.MigrationID] = e
+		case e.MigrationID != 0 && e.Direction == DirectionRevert:
+			delete(applied, e.MigrationID)
+		default:
+			// entry without migration ID (baseline or stored before ID was tracked), only version is known.
+			// everything above the new version is reverted, everything up to it is applied.
+			for id := range applied {
+				if id > e.Version {
+					delete(applied, id)
+				}
+			}
+			for _, mig := range ms {
+				if _, ok := applied[mig.ID]; !ok && mig.ID <= e.Version {
+					applied[mig.ID] = LogEntry{Version: mig.ID, Reason: e.Reason, CreatedAt: e.CreatedAt}
+				}
+			}
+			if e.Version > prev {
+				applied[e.Version] = e
+			}
+		}
+		prev = e.Version
+	}
+	return applied
+}
+
+func (m *mig) step(ctx context.Context, step Step, doStep func(ctx context.Context, step Step) error) error {
 	if m.Timeout != 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
 		defer cancel()
 	}
-	return m.DoStep(ctx, step)
+	return doStep(ctx, step)
 }
 
-// getCurrAndTargetVersions returns current version of the schema and the target version based on run config.
-func (m *mig) getCurrAndTargetVersions(ctx context.Context, migrations int) (curr, target int, err error) {
-	curr, err = m.Version(ctx)
+// getCurrAndTargetVersions returns positions of the current and the target versions based on run config.
+// Position is a number of migrations up to the version, when IDs are not sparse it is equal to the version.
+func (m *mig) getCurrAndTargetVersions(ctx context.Context, ms []*Migration) (curr, target int, err error) {
+	version, err := m.Migrator.Version(ctx)
 	if err != nil {
 		return 0, 0, fmt.Errorf("get version: %w", err)
 	}
 
+	curr, ok := position(ms, version)
+	if !ok {
+		if last := lastVersion(ms); version > last {
+			return 0, 0, fmt.Errorf("current %d is greater than the last migration %d", version, last)
+		}
+		return 0, 0, fmt.Errorf("current %d is not found in migrations", version)
+	}
+
 	switch m.Mode {
 	case ModeApplyAll:
-		target = migrations
-		if curr > target {
-			return 0, 0, errors.New("current is greater than target")
-		}
+		target = len(ms)
 
 	case ModeApplyN:
 		target = curr + m.Num
-		if target > migrations {
+		if target > len(ms) {
 			return 0, 0, errors.New("target is greater than migrations count")
 		}
 
 	case ModeRevertN:
-		if curr > migrations {
-			return 0, 0, errors.New("current is greater than migrations count")
-		}
 		target = curr - m.Num
-
-	case ModeRevertAll:
-		if curr > migrations {
-			return 0, 0, errors.New("current is greater than migrations count")
+		if target < 0 {
+			return 0, 0, errors.New("num is greater than applied migrations count")
 		}
+
+	case ModeRevertAll, ModeDrop:
 		target = 0
 
 	case ModeRedo:
-		if curr > migrations {
-			return 0, 0, errors.New("current is greater than migrations count")
+		if curr == 0 {
+			return 0, 0, errors.New("no migration to redo")
 		}
 		target = curr
 
-	case ModeDrop:
-		target = 0
+	case ModeRepairChecksums, ModeBaseline, ModeForceVersion:
+		target = curr
+
+	case ModeApplyTo:
+		target, ok = position(ms, m.Config.Version)
+		if !ok {
+			return 0, 0, fmt.Errorf("target %d is not found in migrations", m.Config.Version)
+		}
+		if curr > target {
+			return 0, 0, fmt.Errorf("current %d is greater than target %d", version, m.Config.Version)
+		}
+
+	case ModeRevertTo:
+		target, ok = position(ms, m.Config.Version)
+		if !ok {
+			return 0, 0, fmt.Errorf("target %d is not found in migrations", m.Config.Version)
+		}
+		if curr < target {
+			return 0, 0, fmt.Errorf("current %d is less than target %d", version, m.Config.Version)
+		}
 
 	default:
 		panic("unreachable")
@@ -318,17 +1302,48 @@ func (m *mig) getCurrAndTargetVersions(ctx context.Context, migrations int) (cur
 	return curr, target, nil
 }
 
-func (m *mig) prepareSteps(curr, target int, ms []*Migration) []Step {
+// position of the version in sorted migrations, 0 for the zero version.
+// Reports false when there is no migration with such version.
+func position(ms []*Migration, version int) (int, bool) {
+	if version == 0 {
+		return 0, true
+	}
+	idx := sort.Search(len(ms), func(i int) bool {
+		return ms[i].ID >= version
+	})
+	if idx < len(ms) && ms[idx].ID == version {
+		return idx + 1, true
+	}
+	return idx, false
+}
+
+// lastVersion returns ID of the last migration or 0 if there are no migrations.
+func lastVersion(ms []*Migration) int {
+	if len(ms) == 0 {
+		return 0
+	}
+	return ms[len(ms)-1].ID
+}
+
+func (m *mig) prepareSteps(curr, target int, ms []*Migration) ([]Step, error) {
 	if m.Mode == ModeRedo {
 		// undo & do current step.
-		return []Step{
-			ms[curr-1].toStep(false, m.DisableTx),
-			ms[curr-1].toStep(true, m.DisableTx),
+		mig := ms[curr-1]
+		if m.skipped(mig) {
+			return nil, nil
 		}
+		if mig.Irreversible {
+			return nil, &IrreversibleError{ID: mig.ID, Name: mig.Name}
+		}
+		prev := lastVersion(ms[:curr-1])
+		return []Step{
+			mig.toStep(false, prev, PhaseMain, m.DisableTx),
+			mig.toStep(true, prev, PhaseMain, m.DisableTx),
+		}, nil
 	}
 
 	if curr == target {
-		return nil
+		return nil, nil
 	}
 	steps := []Step{}
 
@@ -343,30 +1358,66 @@ func (m *mig) prepareSteps(curr, target int, ms []*Migration) []Step {
 		if !isUp {
 			idx--
 		}
+		prev := lastVersion(ms[:idx])
 
-		steps = append(steps, ms[idx].toStep(isUp, m.DisableTx))
+		// skipped migration is passed by a step that changes only the version.
+		if m.skipped(ms[idx]) {
+			steps = append(steps, ms[idx].skipStep(isUp, prev))
+			continue
+		}
+
+		// revert is required by the main step or by ZigZag.
+		if ms[idx].Irreversible && (!isUp || m.ZigZag) {
+			return nil, &IrreversibleError{ID: ms[idx].ID, Name: ms[idx].Name}
+		}
+
+		steps = append(steps, ms[idx].toStep(isUp, prev, PhaseMain, m.DisableTx))
 		if m.ZigZag {
 			steps = append(steps,
-				ms[idx].toStep(!isUp, m.DisableTx),
-				ms[idx].toStep(isUp, m.DisableTx))
+				ms[idx].toStep(!isUp, prev, PhaseZigZag, m.DisableTx),
+				ms[idx].toStep(isUp, prev, PhaseZigZag, m.DisableTx))
 		}
 	}
-	return steps
+	return steps, nil
+}
+
+// skipped reports whether the migration does not match Config.Labels.
+func (m *mig) skipped(mig *Migration) bool {
+	return m.labels != nil && len(mig.Labels) != 0 && !m.labels(mig.Labels)
 }
 
-func (m *Migration) toStep(up, disableTx bool) Step {
+// toStep creates a step from the migration, prev is a version before this migration.
+func (m *Migration) toStep(up bool, prev int, phase Phase, disableTx bool) Step {
 	if up {
 		return Step{
-			Version:   m.ID,
-			Query:     m.Apply,
-			DisableTx: disableTx,
+			Version:     m.ID,
+			Query:       m.Apply,
+			DisableTx:   disableTx || m.ApplyDirectives.NoTransaction,
+			Name:        m.Name,
+			Direction:   DirectionApply,
+			Checksum:    m.Checksum,
+			MigrationID: m.ID,
+			Phase:       phase,
+			Func:        m.ApplyFunc,
 		}
 	}
 	return Step{
-		Version:   m.ID - 1,
-		Query:     m.Revert,
-		DisableTx: disableTx,
+		Version:     prev,
+		Query:       m.Revert,
+		DisableTx:   disableTx || m.RevertDirectives.NoTransaction,
+		Name:        m.Name,
+		Direction:   DirectionRevert,
+		MigrationID: m.ID,
+		Phase:       phase,
+		Func:        m.RevertFunc,
 	}
 }
 
+// skipStep creates a step with PhaseSkipped that doesn't run the migration.
+func (m *Migration) skipStep(up bool, prev int) Step {
+	step := m.toStep(up, prev, PhaseSkipped, false)
+	step.Query, step.Func, step.DisableTx = "", nil, false
+	return step
+}
+
 func noopHook(context.Context, Step) {}
@@ -5,17 +5,26 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
 	"github.com/cristalhq/dbump"
 )
 
-var _ dbump.Migrator = &Migrator{}
+var (
+	_ dbump.HistoryMigrator    = &Migrator{}
+	_ dbump.VersionSetter      = &Migrator{}
+	_ dbump.RepeatableMigrator = &Migrator{}
+)
+
+// phaseRepair marks rows with a checksum set by SetChecksum, see History.
+const phaseRepair dbump.Phase = "repair"
 
 // Migrator to migrate ClickHouse.
 type Migrator struct {
-	conn *sql.DB
-	cfg  Config
+	conn   *sql.DB
+	cfg    Config
+	locker *dbump.LeaseLocker
 }
 
 // Config for the migrator.
@@ -28,8 +37,18 @@ type Config struct {
 	OnCluster bool
 	// Engine
 	Engine string
+	// SplitStatements runs each statement of a step separately, see dbump.SplitStatements.
+	// ClickHouse doesn't support few statements in one query. Default is false.
+	SplitStatements bool
+	// LockTable for the lease lock, see dbump.LeaseLocker.
+	// Default is empty which means "_dbump_lock" table.
+	LockTable string
+	// LockTTL after which lock of a crashed migration can be taken.
+	// Default is 0 which means 1 minute.
+	LockTTL time.Duration
 
-	tableName string
+	tableName     string
+	lockTableName string
 }
 
 // NewMigrator instantiates new Migrator.
@@ -43,11 +62,17 @@ func NewMigrator(conn *sql.DB, cfg Config) *Migrator {
 	if cfg.Engine == "" {
 		cfg.Engine = "TinyLog"
 	}
+	if cfg.LockTable == "" {
+		cfg.LockTable = "_dbump_lock"
+	}
 	cfg.tableName = cfg.Database + cfg.Table
+	cfg.lockTableName = cfg.Database + cfg.LockTable
 
+	store := &leaseStore{conn: conn, cfg: cfg}
 	return &Migrator{
-		conn: conn,
-		cfg:  cfg,
+		conn:   conn,
+		cfg:    cfg,
+		locker: dbump.NewLeaseLocker(store, dbump.LeaseConfig{TTL: cfg.LockTTL}),
 	}
 }
 
@@ -62,20 +87,46 @@ func (ch *Migrator) Init(ctx context.Context) error {
 	version    BIGINT NOT NULL,
 	created_at TIMESTAMP NOT NULL
 ) ENGINE = %s;`, ch.cfg.tableName, withCluster, ch.cfg.Engine)
+	if _, err := ch.conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	// columns after created_at are added separately to upgrade tables created by previous versions.
+	// created_at has second precision, entries are ordered by logged_at.
+	query = fmt.Sprintf(`ALTER TABLE %s%s
+	ADD COLUMN IF NOT EXISTS logged_at    DateTime64(6) DEFAULT toDateTime64(created_at, 6),
+	ADD COLUMN IF NOT EXISTS checksum     String DEFAULT '',
+	ADD COLUMN IF NOT EXISTS migration_id BIGINT DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS name         String DEFAULT '',
+	ADD COLUMN IF NOT EXISTS direction    String DEFAULT '',
+	ADD COLUMN IF NOT EXISTS phase        String DEFAULT '',
+	ADD COLUMN IF NOT EXISTS reason       String DEFAULT '';`, ch.cfg.tableName, withCluster)
+	_, err := ch.conn.ExecContext(ctx, query)
+	return err
+}
+
+// Drop is a method from Migrator interface.
+func (ch *Migrator) Drop(ctx context.Context) error {
+	query := fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, ch.cfg.tableName)
 	_, err := ch.conn.ExecContext(ctx, query)
 	return err
 }
 
 // LockDB is a method from Migrator interface.
-func (ch *Migrator) LockDB(ctx context.Context) error { return nil }
+// ClickHouse has no locks, so a lease in Config.LockTable is used.
+func (ch *Migrator) LockDB(ctx context.Context) error {
+	return ch.locker.Lock(ctx)
+}
 
 // UnlockDB is a method from Migrator interface.
-func (ch *Migrator) UnlockDB(ctx context.Context) error { return nil }
+func (ch *Migrator) UnlockDB(ctx context.Context) error {
+	return ch.locker.Unlock(ctx)
+}
 
 // Version is a method from Migrator interface.
 func (ch *Migrator) Version(ctx context.Context) (version int, err error) {
-	query := fmt.Sprintf("SELECT version FROM %s ORDER BY created_at DESC LIMIT 1;", ch.cfg.tableName)
-	row := ch.conn.QueryRowContext(ctx, query)
+	query := fmt.Sprintf("SELECT version FROM %s WHERE phase <> ? ORDER BY logged_at DESC LIMIT 1;", ch.cfg.tableName)
+	row := ch.conn.QueryRowContext(ctx, query, string(phaseRepair))
 	err = row.Scan(&version)
 	if err != nil && errors.Is(err, sql.ErrNoRows) {
 		return 0, nil
@@ -83,19 +134,246 @@ func (ch *Migrator) Version(ctx context.Context) (version int, err error) {
 	return version, err
 }
 
+// History is a method from HistoryMigrator interface.
+func (ch *Migrator) History(ctx context.Context) ([]dbump.LogEntry, error) {
+	query := fmt.Sprintf(`SELECT version, migration_id, name, direction, phase, checksum, reason, created_at
+FROM %s WHERE phase <> ? ORDER BY logged_at;`, ch.cfg.tableName)
+	rows, err := ch.conn.QueryContext(ctx, query, string(dbump.PhaseRepeatable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []dbump.LogEntry
+	for rows.Next() {
+		var e dbump.LogEntry
+		if err := rows.Scan(&e.Version, &e.MigrationID, &e.Name, &e.Direction, &e.Phase, &e.Checksum, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if e.Phase == phaseRepair {
+			repairChecksum(entries, e.MigrationID, e.Checksum)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// repairChecksum sets checksum to the entries of the migration logged before the repair.
+func repairChecksum(entries []dbump.LogEntry, id int, checksum string) {
+	for i, e := range entries {
+		if e.MigrationID == id || (e.MigrationID == 0 && e.Version == id) {
+			entries[i].Checksum = checksum
+		}
+	}
+}
+
+// SetChecksum is a method from HistoryMigrator interface.
+// Rows are never updated, a repair row is appended instead and History applies it.
+func (ch *Migrator) SetChecksum(ctx context.Context, version int, checksum string) error {
+	if err := ch.locker.Err(); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (version, created_at, logged_at, checksum, migration_id, phase)
+VALUES (?, ?, ?, ?, ?, ?);`, ch.cfg.tableName)
+
+	// INSERT is supported only in a batch mode (via transaction).
+	tx, err := ch.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, query, version, now, now, checksum, version, string(phaseRepair)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RepeatableChecksums is a method from RepeatableMigrator interface.
+func (ch *Migrator) RepeatableChecksums(ctx context.Context) (map[string]string, error) {
+	query := fmt.Sprintf(`SELECT name, checksum FROM %s WHERE phase = ? ORDER BY logged_at;`, ch.cfg.tableName)
+	rows, err := ch.conn.QueryContext(ctx, query, string(dbump.PhaseRepeatable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checksums := map[string]string{}
+	for rows.Next() {
+		var name, checksum string
+		if err := rows.Scan(&name, &checksum); err != nil {
+			return nil, err
+		}
+		checksums[name] = checksum
+	}
+	return checksums, rows.Err()
+}
+
+// SetVersion is a method from VersionSetter interface.
+func (ch *Migrator) SetVersion(ctx context.Context, version int, reason string) error {
+	if err := ch.locker.Err(); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (version, created_at, logged_at, reason)
+VALUES (?, ?, ?, ?);`, ch.cfg.tableName)
+
+	// INSERT is supported only in a batch mode (via transaction).
+	tx, err := ch.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, query, version, now, now, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DoStep is a method from Migrator interface.
 func (ch *Migrator) DoStep(ctx context.Context, step dbump.Step) error {
+	dbump.Logger(ctx).DebugContext(ctx, "executing step",
+		slog.String("table", ch.cfg.tableName), slog.Bool("split", ch.cfg.SplitStatements))
+
+	// lease might be taken over by another migration, nothing must be changed then.
+	if err := ch.locker.Err(); err != nil {
+		return err
+	}
+
 	tx, err := ch.conn.Begin()
 	if err != nil {
 		return err
 	}
 	// TODO: rollback
 
-	if _, err := tx.ExecContext(ctx, step.Query); err != nil {
+	switch {
+	case step.Phase == dbump.PhaseSkipped:
+		// nothing to run, only the log is updated.
+	case step.Func != nil:
+		if err := step.Func(ctx, &executor{tx: tx}); err != nil {
+			return err
+		}
+	default:
+		if err := ch.exec(ctx, tx, step.Query); err != nil {
+			return err
+		}
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s (version, created_at, logged_at, checksum, migration_id, name, direction, phase)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?);`, ch.cfg.tableName)
+	now := time.Now().UTC()
+	args := []interface{}{
+		step.Version, now, now, step.Checksum,
+		step.MigrationID, step.Name, string(step.Direction), string(step.Phase),
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ch *Migrator) exec(ctx context.Context, tx *sql.Tx, query string) error {
+	if !ch.cfg.SplitStatements {
+		_, err := tx.ExecContext(ctx, query)
 		return err
 	}
+	num := 0
+	return dbump.ExecStatements(ctx, query, func(ctx context.Context, stmt string) error {
+		num++
+		dbump.Logger(ctx).DebugContext(ctx, "executing statement", slog.Int("num", num))
+		_, err := tx.ExecContext(ctx, stmt)
+		return err
+	})
+}
+
+// executor for dbump.MigrationFunc.
+type executor struct {
+	tx *sql.Tx
+}
 
-	query := fmt.Sprintf("INSERT INTO %s (version, created_at) VALUES (?, ?);", ch.cfg.tableName)
-	if _, err := tx.ExecContext(ctx, query, step.Version, time.Now().UTC()); err != nil {
+// Exec is a method from dbump.Executor interface.
+func (e *executor) Exec(ctx context.Context, query string, args ...interface{}) error {
+	_, err := e.tx.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Query is a method from dbump.Executor interface.
+func (e *executor) Query(ctx context.Context, query string, args ...interface{}) (dbump.Rows, error) {
+	rows, err := e.tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// leaseStore keeps leases in append-only table, the latest row of each owner is the actual one.
+type leaseStore struct {
+	conn *sql.DB
+	cfg  Config
+}
+
+// InitLeases is a method from dbump.LeaseStore interface.
+func (ls *leaseStore) InitLeases(ctx context.Context) error {
+	withCluster := ""
+	if ls.cfg.OnCluster {
+		withCluster = " ON CLUSTER"
+	}
+
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s%s (
+	owner       String NOT NULL,
+	hostname    String NOT NULL,
+	acquired_at DateTime64(3) NOT NULL,
+	expires_at  DateTime64(3) NOT NULL,
+	updated_at  DateTime64(6) NOT NULL
+) ENGINE = %s;`, ls.cfg.lockTableName, withCluster, ls.cfg.Engine)
+	_, err := ls.conn.ExecContext(ctx, query)
+	return err
+}
+
+// Leases is a method from dbump.LeaseStore interface.
+func (ls *leaseStore) Leases(ctx context.Context) ([]dbump.Lease, error) {
+	query := fmt.Sprintf(`SELECT owner,
+	argMax(hostname, updated_at),
+	argMax(acquired_at, updated_at),
+	argMax(expires_at, updated_at)
+FROM %s GROUP BY owner;`, ls.cfg.lockTableName)
+	rows, err := ls.conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var leases []dbump.Lease
+	for rows.Next() {
+		var l dbump.Lease
+		if err := rows.Scan(&l.Owner, &l.Hostname, &l.AcquiredAt, &l.ExpiresAt); err != nil {
+			return nil, err
+		}
+		leases = append(leases, l)
+	}
+	return leases, rows.Err()
+}
+
+// PutLease is a method from dbump.LeaseStore interface.
+func (ls *leaseStore) PutLease(ctx context.Context, lease dbump.Lease) error {
+	query := fmt.Sprintf(`INSERT INTO %s (owner, hostname, acquired_at, expires_at, updated_at)
+VALUES (?, ?, ?, ?, ?);`, ls.cfg.lockTableName)
+
+	// INSERT is supported only in a batch mode (via transaction).
+	tx, err := ls.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, query,
+		lease.Owner, lease.Hostname, lease.AcquiredAt.UTC(), lease.ExpiresAt.UTC(), time.Now().UTC())
+	if err != nil {
 		return err
 	}
 	return tx.Commit()
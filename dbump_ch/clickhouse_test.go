@@ -3,6 +3,7 @@ package dbump_ch
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
@@ -11,6 +12,7 @@ import (
 
 	_ "github.com/ClickHouse/clickhouse-go" // to register ClichHouse client
 	"github.com/cristalhq/dbump"
+	"github.com/cristalhq/dbump/tests"
 )
 
 var conn *sql.DB
@@ -102,12 +104,82 @@ func TestMigrateUp(t *testing.T) {
 			Table: "TestMigrateUp",
 		}),
 		Loader: dbump.NewSliceLoader(migrations),
-		Mode:   dbump.ModeUp,
+		Mode:   dbump.ModeApplyAll,
 	}
 
 	failIfErr(t, dbump.Run(context.Background(), cfg))
 }
 
+func TestLockDB(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{
+		LockTable: "TestLockDB",
+	}
+	first := NewMigrator(conn, cfg)
+	second := NewMigrator(conn, cfg)
+
+	failIfErr(t, first.LockDB(ctx))
+	if err := second.LockDB(ctx); !errors.Is(err, dbump.ErrMigrationAlreadyLocked) {
+		t.Fatalf("want ErrMigrationAlreadyLocked, got %v", err)
+	}
+
+	failIfErr(t, first.UnlockDB(ctx))
+	failIfErr(t, second.LockDB(ctx))
+	failIfErr(t, second.UnlockDB(ctx))
+}
+
+func TestMigrate_ApplyAll(t *testing.T) {
+	newSuite().ApplyAll(t)
+}
+
+func TestMigrate_RevertAll(t *testing.T) {
+	newSuite().RevertAll(t)
+}
+
+func TestMigrate_ChecksumDrift(t *testing.T) {
+	newSuite().ChecksumDrift(t)
+}
+
+func TestMigrate_HistoryReplay(t *testing.T) {
+	newSuite().HistoryReplay(t)
+}
+
+func TestMigrate_Baseline(t *testing.T) {
+	newSuite().Baseline(t)
+}
+
+func TestMigrate_ForceVersion(t *testing.T) {
+	newSuite().ForceVersion(t)
+}
+
+func TestMigrate_Repeatable(t *testing.T) {
+	newSuite().Repeatable(t)
+}
+
+func TestMigrate_UpgradeLegacyTable(t *testing.T) {
+	newSuite().UpgradeLegacyTable(t, func(ctx context.Context) error {
+		_, err := conn.ExecContext(ctx, `CREATE TABLE _dbump_log (
+	version    BIGINT NOT NULL,
+	created_at TIMESTAMP NOT NULL
+) ENGINE = TinyLog;`)
+		if err != nil {
+			return err
+		}
+		_, err = conn.ExecContext(ctx, `INSERT INTO _dbump_log (version, created_at) VALUES (2, now());`)
+		return err
+	})
+}
+
+func newSuite() *tests.MigratorSuite {
+	m := NewMigrator(conn, Config{})
+	suite := tests.NewMigratorSuite(m)
+	suite.ApplyTmpl = "CREATE TABLE %[1]s_%[2]d (id Int32) ENGINE = Memory;"
+	suite.RevertTmpl = "DROP TABLE %[1]s_%[2]d;"
+	suite.CleanMigTmpl = "DROP TABLE IF EXISTS %[1]s_%[2]d;"
+	suite.CleanTest = "TRUNCATE TABLE _dbump_log;"
+	return suite
+}
+
 func failIfErr(t testing.TB, err error) {
 	t.Helper()
 	if err != nil {
@@ -1,8 +1,10 @@
 module github.com/cristalhq/dbump/dbump_ch
 
-go 1.16
+go 1.21
 
 require (
 	github.com/ClickHouse/clickhouse-go v1.5.4
 	github.com/cristalhq/dbump v0.9.0
 )
+
+replace github.com/cristalhq/dbump => ../
@@ -1,8 +1,10 @@
 module github.com/cristalhq/dbump/dbump_mysql
 
-go 1.16
+go 1.21
 
 require (
-	github.com/cristalhq/dbump v0.1.1
+	github.com/cristalhq/dbump v0.14.0
 	github.com/lib/pq v1.10.5
 )
+
+replace github.com/cristalhq/dbump => ../
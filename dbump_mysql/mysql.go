@@ -3,7 +3,15 @@ package dbump_mysql
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+
+	"github.com/cristalhq/dbump"
+)
+
+var (
+	_ dbump.Migrator      = &Migrator{}
+	_ dbump.VersionSetter = &Migrator{}
 )
 
 // to prevent multiple migrations running at the same time
@@ -11,55 +19,232 @@ const lockNum int64 = 777_777_777
 
 // Migrator to migrate MySQL.
 type Migrator struct {
-	db           *sql.DB
-	versionTable string
+	db  *sql.DB
+	cfg Config
+
+	// GET_LOCK is bound to a session, so the same connection must be used to unlock.
+	// While the lock is held all queries go through it, so a pool of 1 connection is enough.
+	lockConn *sql.Conn
+}
+
+// Config for the migrator.
+type Config struct {
+	// Table for the dbump version table. Default is empty which means "_dbump_schema_version" table.
+	Table string
+	// SplitStatements runs each statement of a step separately, see dbump.SplitStatements.
+	// Useful when DSN doesn't have multiStatements=true. Default is false.
+	SplitStatements bool
 }
 
 // NewMigrator instantiates new Migrator.
-func NewMigrator(db *sql.DB) *Migrator {
+func NewMigrator(db *sql.DB, cfg Config) *Migrator {
+	if cfg.Table == "" {
+		cfg.Table = "_dbump_schema_version"
+	}
+
 	return &Migrator{
-		db:           db,
-		versionTable: "_dbump_schema_version",
+		db:  db,
+		cfg: cfg,
 	}
 }
 
-// Init migrator.
-func (pg *Migrator) Init(ctx context.Context) error {
+// Init is a method for Migrator interface.
+// Table keeps a single row with the current version, tables created by previous versions are upgraded.
+func (my *Migrator) Init(ctx context.Context) error {
 	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
 	version    BIGINT NOT NULL PRIMARY KEY,
 	created_at TIMESTAMP NOT NULL
-);`, pg.versionTable)
-	_, err := pg.db.ExecContext(ctx, query)
+);`, my.cfg.Table)
+	if _, err := my.session().ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	// reason is added separately to upgrade tables created by previous versions.
+	rows, err := my.session().QueryContext(ctx, fmt.Sprintf("SHOW COLUMNS FROM %s LIKE 'reason';", my.cfg.Table))
+	if err != nil {
+		return err
+	}
+	hasReason := rows.Next()
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if hasReason {
+		return nil
+	}
+
+	query = fmt.Sprintf("ALTER TABLE %s ADD COLUMN reason TEXT NULL;", my.cfg.Table)
+	_, err = my.session().ExecContext(ctx, query)
+	return err
+}
+
+// Drop is a method for Migrator interface.
+func (my *Migrator) Drop(ctx context.Context) error {
+	query := fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, my.cfg.Table)
+	_, err := my.session().ExecContext(ctx, query)
 	return err
 }
 
 // LockDB is a method for Migrator interface.
+// Returns dbump.ErrMigrationAlreadyLocked when the lock is held by another session.
 func (my *Migrator) LockDB(ctx context.Context) error {
-	_, err := my.db.ExecContext(ctx, `SELECT GET_LOCK(?, 10)`, lockNum)
-	return err
+	conn, err := my.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 1 if the lock was obtained, 0 if it's held by another session and NULL on error.
+	var res sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, 0)", lockNum).Scan(&res)
+	switch {
+	case err != nil:
+		conn.Close()
+		return err
+	case !res.Valid:
+		conn.Close()
+		return errors.New("cannot get lock")
+	case res.Int64 != 1:
+		conn.Close()
+		return dbump.ErrMigrationAlreadyLocked
+	}
+
+	my.lockConn = conn
+	return nil
 }
 
 // UnlockDB is a method for Migrator interface.
+// Lock held by another session cannot be released, so it does nothing when lock wasn't taken.
 func (my *Migrator) UnlockDB(ctx context.Context) error {
-	_, err := my.db.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockNum)
+	if my.lockConn == nil {
+		return nil
+	}
+
+	conn := my.lockConn
+	my.lockConn = nil
+	defer conn.Close()
+
+	_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", lockNum)
 	return err
 }
 
 // Version is a method for Migrator interface.
 func (my *Migrator) Version(ctx context.Context) (version int, err error) {
-	row := my.db.QueryRowContext(ctx, "SELECT version FROM "+my.versionTable)
+	query := fmt.Sprintf("SELECT version FROM %s LIMIT 1;", my.cfg.Table)
+	row := my.session().QueryRowContext(ctx, query)
 	err = row.Scan(&version)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
 	return version, err
 }
 
-// SetVersion is a method for Migrator interface.
-func (my *Migrator) SetVersion(ctx context.Context, version int) error {
-	_, err := my.db.ExecContext(ctx, "UPDATE "+my.versionTable+" SET version = $1", version)
+// SetVersion is a method for VersionSetter interface.
+// Reason is stored in the version row and kept until the next step.
+func (my *Migrator) SetVersion(ctx context.Context, version int, reason string) error {
+	tx, err := my.session().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := my.setVersion(ctx, tx, version, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DoStep is a method for Migrator interface.
+func (my *Migrator) DoStep(ctx context.Context, step dbump.Step) error {
+	if step.DisableTx {
+		return my.doStep(ctx, my.session(), step)
+	}
+
+	tx, err := my.session().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := my.doStep(ctx, tx, step); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (my *Migrator) doStep(ctx context.Context, conn execer, step dbump.Step) error {
+	switch {
+	case step.Phase == dbump.PhaseSkipped:
+		// nothing to run, only the log is updated.
+	case step.Func != nil:
+		if err := step.Func(ctx, &executor{conn: conn}); err != nil {
+			return err
+		}
+	default:
+		if err := my.exec(ctx, conn, step.Query); err != nil {
+			return err
+		}
+	}
+	return my.setVersion(ctx, conn, step.Version, "")
+}
+
+func (my *Migrator) setVersion(ctx context.Context, conn execer, version int, reason string) error {
+	query := fmt.Sprintf("DELETE FROM %s;", my.cfg.Table)
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	query = fmt.Sprintf("INSERT INTO %s (version, created_at, reason) VALUES (?, NOW(), ?);", my.cfg.Table)
+	_, err := conn.ExecContext(ctx, query, version, reason)
 	return err
 }
 
-// Exec is a method for Migrator interface.
-func (my *Migrator) Exec(ctx context.Context, query string, args ...interface{}) error {
-	_, err := my.db.ExecContext(ctx, query)
+func (my *Migrator) exec(ctx context.Context, conn execer, query string) error {
+	if !my.cfg.SplitStatements {
+		_, err := conn.ExecContext(ctx, query)
+		return err
+	}
+	return dbump.ExecStatements(ctx, query, func(ctx context.Context, stmt string) error {
+		_, err := conn.ExecContext(ctx, stmt)
+		return err
+	})
+}
+
+// session returns the connection holding the lock or the pool when the lock isn't taken.
+func (my *Migrator) session() conn {
+	if my.lockConn != nil {
+		return my.lockConn
+	}
+	return my.db
+}
+
+// conn is implemented by *sql.DB and *sql.Conn.
+type conn interface {
+	execer
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// executor for dbump.MigrationFunc.
+type executor struct {
+	conn execer
+}
+
+// Exec is a method for dbump.Executor interface.
+func (e *executor) Exec(ctx context.Context, query string, args ...interface{}) error {
+	_, err := e.conn.ExecContext(ctx, query, args...)
 	return err
 }
+
+// Query is a method for dbump.Executor interface.
+func (e *executor) Query(ctx context.Context, query string, args ...interface{}) (dbump.Rows, error) {
+	rows, err := e.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
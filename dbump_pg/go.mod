@@ -1,6 +1,6 @@
 module github.com/cristalhq/dbump/dbump_pg
 
-go 1.17
+go 1.21
 
 require (
 	github.com/cristalhq/dbump v0.14.0
@@ -6,16 +6,27 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"log/slog"
 
 	"github.com/cristalhq/dbump"
 )
 
-var _ dbump.Migrator = &Migrator{}
+var (
+	_ dbump.HistoryMigrator    = &Migrator{}
+	_ dbump.TxMigrator         = &Migrator{}
+	_ dbump.VersionSetter      = &Migrator{}
+	_ dbump.DirtyMigrator      = &Migrator{}
+	_ dbump.RepeatableMigrator = &Migrator{}
+)
 
 // Migrator to migrate Postgres.
 type Migrator struct {
 	conn *sql.DB
 	cfg  Config
+
+	// advisory lock is bound to a session, so the same connection must be used to unlock.
+	// While the lock is held all queries go through it, so a pool of 1 connection is enough.
+	lockConn *sql.Conn
 }
 
 // Config for the migrator.
@@ -57,38 +68,76 @@ func (pg *Migrator) Init(ctx context.Context) error {
 		query = fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s;`, pg.cfg.Schema)
 	}
 
-	query += fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	// columns after created_at are added separately to upgrade tables created by previous versions.
+	query += fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
 	version    BIGINT NOT NULL,
 	created_at TIMESTAMP WITH TIME ZONE NOT NULL
-);`, pg.cfg.tableName)
+);
+ALTER TABLE %[1]s
+	ADD COLUMN IF NOT EXISTS checksum     TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS migration_id BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS name         TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS direction    TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS phase        TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS reason       TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS state        TEXT NOT NULL DEFAULT '';`, pg.cfg.tableName)
 
-	_, err := pg.conn.ExecContext(ctx, query)
+	_, err := pg.session().ExecContext(ctx, query)
 	return err
 }
 
 // Drop is a method from Migrator interface.
 func (pg *Migrator) Drop(ctx context.Context) error {
 	query := fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, pg.cfg.tableName)
-	_, err := pg.conn.ExecContext(ctx, query)
+	_, err := pg.session().ExecContext(ctx, query)
 	return err
 }
 
 // LockDB is a method from Migrator interface.
+// Returns dbump.ErrMigrationAlreadyLocked when the lock is held by another session.
 func (pg *Migrator) LockDB(ctx context.Context) error {
-	_, err := pg.conn.ExecContext(ctx, "SELECT pg_advisory_lock($1);", pg.cfg.lockNum)
-	return err
+	dbump.Logger(ctx).DebugContext(ctx, "taking advisory lock", slog.Int64("lock", pg.cfg.lockNum))
+
+	conn, err := pg.conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	var locked bool
+	err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1);", pg.cfg.lockNum).Scan(&locked)
+	switch {
+	case err != nil:
+		conn.Close()
+		return err
+	case !locked:
+		conn.Close()
+		return dbump.ErrMigrationAlreadyLocked
+	}
+
+	pg.lockConn = conn
+	return nil
 }
 
 // UnlockDB is a method from Migrator interface.
+// Lock held by another session cannot be released, so it does nothing when lock wasn't taken.
 func (pg *Migrator) UnlockDB(ctx context.Context) error {
-	_, err := pg.conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1);", pg.cfg.lockNum)
+	if pg.lockConn == nil {
+		return nil
+	}
+	dbump.Logger(ctx).DebugContext(ctx, "releasing advisory lock", slog.Int64("lock", pg.cfg.lockNum))
+
+	conn := pg.lockConn
+	pg.lockConn = nil
+	defer conn.Close()
+
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1);", pg.cfg.lockNum)
 	return err
 }
 
 // Version is a method for Migrator interface.
 func (pg *Migrator) Version(ctx context.Context) (version int, err error) {
-	query := fmt.Sprintf("SELECT version FROM %s ORDER BY created_at DESC LIMIT 1;", pg.cfg.tableName)
-	row := pg.conn.QueryRowContext(ctx, query)
+	query := fmt.Sprintf("SELECT version FROM %s WHERE state = '' ORDER BY created_at DESC LIMIT 1;", pg.cfg.tableName)
+	row := pg.session().QueryRowContext(ctx, query)
 	err = row.Scan(&version)
 	if err != nil && errors.Is(err, sql.ErrNoRows) {
 		return 0, nil
@@ -96,29 +145,137 @@ func (pg *Migrator) Version(ctx context.Context) (version int, err error) {
 	return version, err
 }
 
+// History is a method for HistoryMigrator interface.
+func (pg *Migrator) History(ctx context.Context) ([]dbump.LogEntry, error) {
+	query := fmt.Sprintf(`SELECT version, migration_id, name, direction, phase, checksum, reason, created_at
+FROM %s WHERE state = '' AND phase <> $1 ORDER BY created_at;`, pg.cfg.tableName)
+	rows, err := pg.session().QueryContext(ctx, query, dbump.PhaseRepeatable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []dbump.LogEntry
+	for rows.Next() {
+		var e dbump.LogEntry
+		if err := rows.Scan(&e.Version, &e.MigrationID, &e.Name, &e.Direction, &e.Phase, &e.Checksum, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// SetChecksum is a method for HistoryMigrator interface.
+func (pg *Migrator) SetChecksum(ctx context.Context, version int, checksum string) error {
+	query := fmt.Sprintf(`UPDATE %s SET checksum = $1
+WHERE phase <> $3 AND (migration_id = $2 OR (migration_id = 0 AND version = $2));`, pg.cfg.tableName)
+	_, err := pg.session().ExecContext(ctx, query, checksum, version, dbump.PhaseRepeatable)
+	return err
+}
+
+// RepeatableChecksums is a method for RepeatableMigrator interface.
+func (pg *Migrator) RepeatableChecksums(ctx context.Context) (map[string]string, error) {
+	query := fmt.Sprintf(`SELECT name, checksum FROM %s WHERE state = '' AND phase = $1 ORDER BY created_at;`, pg.cfg.tableName)
+	rows, err := pg.session().QueryContext(ctx, query, dbump.PhaseRepeatable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checksums := map[string]string{}
+	for rows.Next() {
+		var name, checksum string
+		if err := rows.Scan(&name, &checksum); err != nil {
+			return nil, err
+		}
+		checksums[name] = checksum
+	}
+	return checksums, rows.Err()
+}
+
+// SetVersion is a method for VersionSetter interface.
+func (pg *Migrator) SetVersion(ctx context.Context, version int, reason string) error {
+	query := fmt.Sprintf(`INSERT INTO %s (version, created_at, reason)
+VALUES ($1, clock_timestamp(), $2);`, pg.cfg.tableName)
+	_, err := pg.session().ExecContext(ctx, query, version, reason)
+	return err
+}
+
+// Dirty is a method for DirtyMigrator interface.
+func (pg *Migrator) Dirty(ctx context.Context) (*dbump.LogEntry, error) {
+	query := fmt.Sprintf(`SELECT version, migration_id, name, direction, phase, state, created_at
+FROM %s ORDER BY created_at DESC LIMIT 1;`, pg.cfg.tableName)
+
+	var e dbump.LogEntry
+	var state string
+	err := pg.session().QueryRowContext(ctx, query).Scan(&e.Version, &e.MigrationID, &e.Name, &e.Direction, &e.Phase, &state, &e.CreatedAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case state != stateStarted:
+		return nil, nil
+	default:
+		return &e, nil
+	}
+}
+
 // DoStep is a method for Migrator interface.
 func (pg *Migrator) DoStep(ctx context.Context, step dbump.Step) error {
+	dbump.Logger(ctx).DebugContext(ctx, "executing step",
+		slog.String("table", pg.cfg.tableName), slog.Bool("tx", !step.DisableTx))
+
 	if step.DisableTx {
-		if _, err := pg.conn.ExecContext(ctx, step.Query); err != nil {
+		// step might fail halfway, mark it as started to detect this on the next run.
+		query := fmt.Sprintf(`INSERT INTO %s (version, created_at, migration_id, name, direction, phase, state)
+VALUES ($1, clock_timestamp(), $2, $3, $4, $5, $6);`, pg.cfg.tableName)
+		_, err := pg.session().ExecContext(ctx, query,
+			step.Version, step.MigrationID, step.Name, step.Direction, step.Phase, stateStarted)
+		if err != nil {
 			return err
 		}
-		query := fmt.Sprintf("INSERT INTO %s (version, created_at) VALUES ($1, NOW());", pg.cfg.tableName)
-		_, err := pg.conn.ExecContext(ctx, query, step.Version)
-		return err
+		return pg.doStep(ctx, pg.session(), step)
 	}
 
 	return pg.beginFunc(ctx, func(tx *sql.Tx) error {
-		if _, err := tx.ExecContext(ctx, step.Query); err != nil {
+		return pg.doStep(ctx, tx, step)
+	})
+}
+
+// InTx is a method for dbump.TxMigrator interface.
+func (pg *Migrator) InTx(ctx context.Context, fn func(ctx context.Context, doStep func(ctx context.Context, step dbump.Step) error) error) error {
+	return pg.beginFunc(ctx, func(tx *sql.Tx) error {
+		return fn(ctx, func(ctx context.Context, step dbump.Step) error {
+			return pg.doStep(ctx, tx, step)
+		})
+	})
+}
+
+func (pg *Migrator) doStep(ctx context.Context, conn execer, step dbump.Step) error {
+	switch {
+	case step.Phase == dbump.PhaseSkipped:
+		// nothing to run, only the log is updated.
+	case step.Func != nil:
+		if err := step.Func(ctx, &executor{conn: conn}); err != nil {
 			return err
 		}
-		query := fmt.Sprintf("INSERT INTO %s (version, created_at) VALUES ($1, NOW());", pg.cfg.tableName)
-		_, err := tx.ExecContext(ctx, query, step.Version)
-		return err
-	})
+	default:
+		if _, err := conn.ExecContext(ctx, step.Query); err != nil {
+			return err
+		}
+	}
+	// clock_timestamp instead of NOW to keep the order of steps done in one transaction.
+	query := fmt.Sprintf(`INSERT INTO %s (version, created_at, checksum, migration_id, name, direction, phase)
+VALUES ($1, clock_timestamp(), $2, $3, $4, $5, $6);`, pg.cfg.tableName)
+	_, err := conn.ExecContext(ctx, query,
+		step.Version, step.Checksum, step.MigrationID, step.Name, step.Direction, step.Phase)
+	return err
 }
 
 func (pg *Migrator) beginFunc(ctx context.Context, f func(*sql.Tx) error) (err error) {
-	tx, err := pg.conn.BeginTx(ctx, nil)
+	tx, err := pg.session().BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -131,6 +288,51 @@ func (pg *Migrator) beginFunc(ctx context.Context, f func(*sql.Tx) error) (err e
 	return tx.Commit()
 }
 
+// session returns the connection holding the lock or the pool when the lock isn't taken.
+func (pg *Migrator) session() conn {
+	if pg.lockConn != nil {
+		return pg.lockConn
+	}
+	return pg.conn
+}
+
+// conn is implemented by *sql.DB and *sql.Conn.
+type conn interface {
+	execer
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// stateStarted marks a step without transaction that has been started.
+// Finished steps have an empty state.
+const stateStarted = "started"
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// executor for dbump.MigrationFunc.
+type executor struct {
+	conn execer
+}
+
+// Exec is a method for dbump.Executor interface.
+func (e *executor) Exec(ctx context.Context, query string, args ...interface{}) error {
+	_, err := e.conn.ExecContext(ctx, query, args...)
+	return err
+}
+
+// Query is a method for dbump.Executor interface.
+func (e *executor) Query(ctx context.Context, query string, args ...interface{}) (dbump.Rows, error) {
+	rows, err := e.conn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
 func hashTableName(s string) int64 {
 	h := fnv.New64()
 	h.Write([]byte(s))
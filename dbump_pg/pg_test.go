@@ -1,6 +1,7 @@
 package dbump_pg
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
@@ -107,6 +108,53 @@ func TestMigrate_Redo(t *testing.T) {
 	newSuite().Redo(t)
 }
 
+func TestMigrate_ApplyTo(t *testing.T) {
+	newSuite().ApplyTo(t)
+}
+
+func TestMigrate_RevertTo(t *testing.T) {
+	newSuite().RevertTo(t)
+}
+
+func TestMigrate_ChecksumDrift(t *testing.T) {
+	newSuite().ChecksumDrift(t)
+}
+
+func TestMigrate_HistoryReplay(t *testing.T) {
+	newSuite().HistoryReplay(t)
+}
+
+func TestMigrate_SingleTx(t *testing.T) {
+	newSuite().SingleTx(t)
+}
+
+func TestMigrate_Baseline(t *testing.T) {
+	newSuite().Baseline(t)
+}
+
+func TestMigrate_ForceVersion(t *testing.T) {
+	newSuite().ForceVersion(t)
+}
+
+func TestMigrate_Dirty(t *testing.T) {
+	newSuite().Dirty(t)
+}
+
+func TestMigrate_Repeatable(t *testing.T) {
+	newSuite().Repeatable(t)
+}
+
+func TestMigrate_UpgradeLegacyTable(t *testing.T) {
+	newSuite().UpgradeLegacyTable(t, func(ctx context.Context) error {
+		_, err := sqldb.ExecContext(ctx, `CREATE TABLE public._dbump_log (
+	version    BIGINT NOT NULL,
+	created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+INSERT INTO public._dbump_log (version, created_at) VALUES (2, now());`)
+		return err
+	})
+}
+
 func newSuite() *tests.MigratorSuite {
 	m := NewMigrator(sqldb, Config{})
 	suite := tests.NewMigratorSuite(m)
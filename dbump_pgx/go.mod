@@ -1,6 +1,6 @@
 module github.com/cristalhq/dbump/dbump_pgx
 
-go 1.18
+go 1.21
 
 require (
 	github.com/cristalhq/dbump v0.14.0
@@ -13,3 +13,5 @@ require (
 	golang.org/x/crypto v0.9.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 )
+
+replace github.com/cristalhq/dbump => ../
@@ -5,12 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"log/slog"
 
 	"github.com/cristalhq/dbump"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
-var _ dbump.Migrator = &Migrator{}
+var (
+	_ dbump.HistoryMigrator    = &Migrator{}
+	_ dbump.TxMigrator         = &Migrator{}
+	_ dbump.VersionSetter      = &Migrator{}
+	_ dbump.DirtyMigrator      = &Migrator{}
+	_ dbump.RepeatableMigrator = &Migrator{}
+)
 
 // Migrator to migrate Postgres.
 type Migrator struct {
@@ -56,10 +64,19 @@ func (pg *Migrator) Init(ctx context.Context) error {
 		query = fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s;`, pg.cfg.Schema)
 	}
 
-	query += fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	// columns after created_at are added separately to upgrade tables created by previous versions.
+	query += fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
 	version    BIGINT NOT NULL,
 	created_at TIMESTAMP WITH TIME ZONE NOT NULL
-);`, pg.cfg.tableName)
+);
+ALTER TABLE %[1]s
+	ADD COLUMN IF NOT EXISTS checksum     TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS migration_id BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS name         TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS direction    TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS phase        TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS reason       TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS state        TEXT NOT NULL DEFAULT '';`, pg.cfg.tableName)
 
 	_, err := pg.conn.Exec(ctx, query)
 	return err
@@ -78,20 +95,31 @@ func (pg *Migrator) Drop(ctx context.Context) error {
 }
 
 // LockDB is a method from Migrator interface.
+// Returns dbump.ErrMigrationAlreadyLocked when the lock is held by another session.
 func (pg *Migrator) LockDB(ctx context.Context) error {
-	_, err := pg.conn.Exec(ctx, "SELECT pg_advisory_lock($1);", pg.cfg.lockNum)
-	return err
+	dbump.Logger(ctx).DebugContext(ctx, "taking advisory lock", slog.Int64("lock", pg.cfg.lockNum))
+
+	var locked bool
+	err := pg.conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1);", pg.cfg.lockNum).Scan(&locked)
+	switch {
+	case err != nil:
+		return err
+	case !locked:
+		return dbump.ErrMigrationAlreadyLocked
+	}
+	return nil
 }
 
 // UnlockDB is a method from Migrator interface.
 func (pg *Migrator) UnlockDB(ctx context.Context) error {
+	dbump.Logger(ctx).DebugContext(ctx, "releasing advisory lock", slog.Int64("lock", pg.cfg.lockNum))
 	_, err := pg.conn.Exec(ctx, "SELECT pg_advisory_unlock($1);", pg.cfg.lockNum)
 	return err
 }
 
 // Version is a method from Migrator interface.
 func (pg *Migrator) Version(ctx context.Context) (version int, err error) {
-	query := fmt.Sprintf("SELECT version FROM %s ORDER BY created_at DESC LIMIT 1;", pg.cfg.tableName)
+	query := fmt.Sprintf("SELECT version FROM %s WHERE state = '' ORDER BY created_at DESC LIMIT 1;", pg.cfg.tableName)
 	row := pg.conn.QueryRow(ctx, query)
 	err = row.Scan(&version)
 	if err != nil && errors.Is(err, pgx.ErrNoRows) {
@@ -100,25 +128,174 @@ func (pg *Migrator) Version(ctx context.Context) (version int, err error) {
 	return version, err
 }
 
-// Version is a method from Migrator interface.
+// History is a method from HistoryMigrator interface.
+func (pg *Migrator) History(ctx context.Context) ([]dbump.LogEntry, error) {
+	query := fmt.Sprintf(`SELECT version, migration_id, name, direction, phase, checksum, reason, created_at
+FROM %s WHERE state = '' AND phase <> $1 ORDER BY created_at;`, pg.cfg.tableName)
+	rows, err := pg.conn.Query(ctx, query, dbump.PhaseRepeatable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []dbump.LogEntry
+	for rows.Next() {
+		var e dbump.LogEntry
+		if err := rows.Scan(&e.Version, &e.MigrationID, &e.Name, &e.Direction, &e.Phase, &e.Checksum, &e.Reason, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// SetChecksum is a method from HistoryMigrator interface.
+func (pg *Migrator) SetChecksum(ctx context.Context, version int, checksum string) error {
+	query := fmt.Sprintf(`UPDATE %s SET checksum = $1
+WHERE phase <> $3 AND (migration_id = $2 OR (migration_id = 0 AND version = $2));`, pg.cfg.tableName)
+	_, err := pg.conn.Exec(ctx, query, checksum, version, dbump.PhaseRepeatable)
+	return err
+}
+
+// RepeatableChecksums is a method from RepeatableMigrator interface.
+func (pg *Migrator) RepeatableChecksums(ctx context.Context) (map[string]string, error) {
+	query := fmt.Sprintf(`SELECT name, checksum FROM %s WHERE state = '' AND phase = $1 ORDER BY created_at;`, pg.cfg.tableName)
+	rows, err := pg.conn.Query(ctx, query, dbump.PhaseRepeatable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checksums := map[string]string{}
+	for rows.Next() {
+		var name, checksum string
+		if err := rows.Scan(&name, &checksum); err != nil {
+			return nil, err
+		}
+		checksums[name] = checksum
+	}
+	return checksums, rows.Err()
+}
+
+// SetVersion is a method from VersionSetter interface.
+func (pg *Migrator) SetVersion(ctx context.Context, version int, reason string) error {
+	query := fmt.Sprintf(`INSERT INTO %s (version, created_at, reason)
+VALUES ($1, clock_timestamp(), $2);`, pg.cfg.tableName)
+	_, err := pg.conn.Exec(ctx, query, version, reason)
+	return err
+}
+
+// Dirty is a method from DirtyMigrator interface.
+func (pg *Migrator) Dirty(ctx context.Context) (*dbump.LogEntry, error) {
+	query := fmt.Sprintf(`SELECT version, migration_id, name, direction, phase, state, created_at
+FROM %s ORDER BY created_at DESC LIMIT 1;`, pg.cfg.tableName)
+
+	var e dbump.LogEntry
+	var state string
+	err := pg.conn.QueryRow(ctx, query).Scan(&e.Version, &e.MigrationID, &e.Name, &e.Direction, &e.Phase, &state, &e.CreatedAt)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case state != stateStarted:
+		return nil, nil
+	default:
+		return &e, nil
+	}
+}
+
+// DoStep is a method from Migrator interface.
 func (pg *Migrator) DoStep(ctx context.Context, step dbump.Step) error {
+	dbump.Logger(ctx).DebugContext(ctx, "executing step",
+		slog.String("table", pg.cfg.tableName), slog.Bool("tx", !step.DisableTx))
+
 	if step.DisableTx {
-		if _, err := pg.conn.Exec(ctx, step.Query); err != nil {
+		// step might fail halfway, mark it as started to detect this on the next run.
+		query := fmt.Sprintf(`INSERT INTO %s (version, created_at, migration_id, name, direction, phase, state)
+VALUES ($1, clock_timestamp(), $2, $3, $4, $5, $6);`, pg.cfg.tableName)
+		_, err := pg.conn.Exec(ctx, query,
+			step.Version, step.MigrationID, step.Name, step.Direction, step.Phase, stateStarted)
+		if err != nil {
 			return err
 		}
-		query := fmt.Sprintf("INSERT INTO %s (version, created_at) VALUES ($1, NOW());", pg.cfg.tableName)
-		_, err := pg.conn.Exec(ctx, query, step.Version)
-		return err
+		return pg.doStep(ctx, pg.conn, step)
 	}
 
 	return pgx.BeginFunc(ctx, pg.conn, func(tx pgx.Tx) error {
-		if _, err := tx.Exec(ctx, step.Query); err != nil {
+		return pg.doStep(ctx, tx, step)
+	})
+}
+
+// InTx is a method from dbump.TxMigrator interface.
+func (pg *Migrator) InTx(ctx context.Context, fn func(ctx context.Context, doStep func(ctx context.Context, step dbump.Step) error) error) error {
+	return pgx.BeginFunc(ctx, pg.conn, func(tx pgx.Tx) error {
+		return fn(ctx, func(ctx context.Context, step dbump.Step) error {
+			return pg.doStep(ctx, tx, step)
+		})
+	})
+}
+
+func (pg *Migrator) doStep(ctx context.Context, conn execer, step dbump.Step) error {
+	switch {
+	case step.Phase == dbump.PhaseSkipped:
+		// nothing to run, only the log is updated.
+	case step.Func != nil:
+		if err := step.Func(ctx, &executor{conn: conn}); err != nil {
 			return err
 		}
-		query := fmt.Sprintf("INSERT INTO %s (version, created_at) VALUES ($1, NOW());", pg.cfg.tableName)
-		_, err := tx.Exec(ctx, query, step.Version)
-		return err
-	})
+	default:
+		if _, err := conn.Exec(ctx, step.Query); err != nil {
+			return err
+		}
+	}
+	// clock_timestamp instead of NOW to keep the order of steps done in one transaction.
+	query := fmt.Sprintf(`INSERT INTO %s (version, created_at, checksum, migration_id, name, direction, phase)
+VALUES ($1, clock_timestamp(), $2, $3, $4, $5, $6);`, pg.cfg.tableName)
+	_, err := conn.Exec(ctx, query,
+		step.Version, step.Checksum, step.MigrationID, step.Name, step.Direction, step.Phase)
+	return err
+}
+
+// stateStarted marks a step without transaction that has been started.
+// Finished steps have an empty state.
+const stateStarted = "started"
+
+// execer is implemented by *pgx.Conn and pgx.Tx.
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// executor for dbump.MigrationFunc.
+type executor struct {
+	conn execer
+}
+
+// Exec is a method for dbump.Executor interface.
+func (e *executor) Exec(ctx context.Context, query string, args ...interface{}) error {
+	_, err := e.conn.Exec(ctx, query, args...)
+	return err
+}
+
+// Query is a method for dbump.Executor interface.
+func (e *executor) Query(ctx context.Context, query string, args ...interface{}) (dbump.Rows, error) {
+	rows, err := e.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &pgxRows{Rows: rows}, nil
+}
+
+// pgxRows adapts pgx.Rows to dbump.Rows.
+type pgxRows struct {
+	pgx.Rows
+}
+
+// Close is a method for dbump.Rows interface.
+func (r *pgxRows) Close() error {
+	r.Rows.Close()
+	return nil
 }
 
 func hashTableName(s string) int64 {
@@ -1,10 +1,13 @@
 package dbump_test
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -58,6 +61,33 @@ func TestRunCheck(t *testing.T) {
 				Mode:     dbump.ModeRevertN,
 			},
 		},
+		{
+			testName: "single tx with disabled tx",
+			cfg: dbump.Config{
+				Migrator:  &tests.MockMigrator{},
+				Loader:    dbump.NewSliceLoader(nil),
+				Mode:      dbump.ModeApplyAll,
+				SingleTx:  true,
+				DisableTx: true,
+			},
+		},
+		{
+			testName: "force version without reason",
+			cfg: dbump.Config{
+				Migrator: &tests.MockMigrator{},
+				Loader:   dbump.NewSliceLoader(nil),
+				Mode:     dbump.ModeForceVersion,
+			},
+		},
+		{
+			testName: "negative lock timeout",
+			cfg: dbump.Config{
+				Migrator:    &tests.MockMigrator{},
+				Loader:      dbump.NewSliceLoader(nil),
+				Mode:        dbump.ModeApplyAll,
+				LockTimeout: -time.Second,
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -109,211 +139,1473 @@ func TestMigrate_RevertAllWhenEmpty(t *testing.T) {
 			return 0, nil
 		},
 	}
-	suite := tests.NewMigratorSuite(mm)
+	suite := tests.NewMigratorSuite(mm)
+
+	suite.RevertAllWhenEmpty(t)
+}
+
+func TestMigrate_RevertAll(t *testing.T) {
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 5, nil
+		},
+	}
+	suite := tests.NewMigratorSuite(mm)
+	suite.RevertAll(t)
+}
+
+func TestMigrate_Redo(t *testing.T) {
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 3, nil
+		},
+	}
+	suite := tests.NewMigratorSuite(mm)
+	suite.Redo(t)
+}
+
+func TestMigrate_ApplyTo(t *testing.T) {
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 1, nil
+		},
+	}
+	suite := tests.NewMigratorSuite(mm)
+	suite.ApplyTo(t)
+}
+
+func TestMigrate_RevertTo(t *testing.T) {
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 4, nil
+		},
+	}
+	suite := tests.NewMigratorSuite(mm)
+	suite.RevertTo(t)
+}
+
+func TestTargetVersionOutOfRange(t *testing.T) {
+	testCases := []struct {
+		testName string
+		mode     dbump.MigratorMode
+		curr     int
+		version  int
+		wantErr  string
+	}{
+		{
+			testName: "negative version",
+			mode:     dbump.ModeRevertTo,
+			curr:     3,
+			version:  -1,
+			wantErr:  "version must not be negative: -1",
+		},
+		{
+			testName: "apply to greater than count",
+			mode:     dbump.ModeApplyTo,
+			curr:     3,
+			version:  6,
+			wantErr:  "version get: target 6 is not found in migrations",
+		},
+		{
+			testName: "apply to lower than current",
+			mode:     dbump.ModeApplyTo,
+			curr:     3,
+			version:  2,
+			wantErr:  "version get: current 3 is greater than target 2",
+		},
+		{
+			testName: "revert to greater than current",
+			mode:     dbump.ModeRevertTo,
+			curr:     3,
+			version:  4,
+			wantErr:  "version get: current 3 is less than target 4",
+		},
+	}
+
+	for _, tc := range testCases {
+		curr := tc.curr
+		cfg := dbump.Config{
+			Migrator: &tests.MockMigrator{
+				VersionFn: func(ctx context.Context) (version int, err error) {
+					return curr, nil
+				},
+			},
+			Loader:  dbump.NewSliceLoader(testdataMigrations),
+			Mode:    tc.mode,
+			Version: tc.version,
+		}
+
+		_, err := dbump.Plan(context.Background(), cfg)
+		if err == nil {
+			t.Fatalf("%s: want error", tc.testName)
+		}
+		mustEqual(t, err.Error(), tc.wantErr)
+	}
+}
+
+func TestSparseIDs(t *testing.T) {
+	migs := []*dbump.Migration{
+		{ID: 20221016120000, Name: "20221016120000_a.sql", Apply: "SELECT 1;", Revert: "SELECT 10;"},
+		{ID: 20221017090000, Name: "20221017090000_b.sql", Apply: "SELECT 2;", Revert: "SELECT 20;"},
+		{ID: 20221101000000, Name: "20221101000000_c.sql", Apply: "SELECT 3;", Revert: "SELECT 30;"},
+	}
+
+	testCases := []struct {
+		testName string
+		mode     dbump.MigratorMode
+		curr     int
+		num      int
+		wantLog  []string
+	}{
+		{
+			testName: "apply all",
+			mode:     dbump.ModeApplyAll,
+			curr:     20221016120000,
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"dostep", "{v:20221017090000 q:'SELECT 2;' notx:false}",
+				"dostep", "{v:20221101000000 q:'SELECT 3;' notx:false}",
+				"unlockdb",
+			},
+		},
+		{
+			testName: "revert n",
+			mode:     dbump.ModeRevertN,
+			curr:     20221101000000,
+			num:      2,
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"dostep", "{v:20221017090000 q:'SELECT 30;' notx:false}",
+				"dostep", "{v:20221016120000 q:'SELECT 20;' notx:false}",
+				"unlockdb",
+			},
+		},
+		{
+			testName: "redo",
+			mode:     dbump.ModeRedo,
+			curr:     20221016120000,
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"dostep", "{v:0 q:'SELECT 10;' notx:false}",
+				"dostep", "{v:20221016120000 q:'SELECT 1;' notx:false}",
+				"unlockdb",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		curr := tc.curr
+		mm := &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return curr, nil
+			},
+		}
+		cfg := dbump.Config{
+			Migrator:  mm,
+			Loader:    dbump.NewSliceLoader(migs),
+			Mode:      tc.mode,
+			Num:       tc.num,
+			SparseIDs: true,
+		}
+
+		failIfErr(t, dbump.Run(context.Background(), cfg))
+		mustEqual(t, mm.Log(), tc.wantLog)
+	}
+}
+
+func TestSparseIDsUnknownVersion(t *testing.T) {
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 20221016120001, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader: dbump.NewSliceLoader([]*dbump.Migration{
+			{ID: 20221016120000},
+			{ID: 20221017090000},
+		}),
+		Mode:      dbump.ModeApplyAll,
+		SparseIDs: true,
+	}
+
+	_, err := dbump.Plan(context.Background(), cfg)
+	mustEqual(t, err.Error(), "version get: current 20221016120001 is not found in migrations")
+}
+
+func TestMigrate_Drop(t *testing.T) {
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 5, nil
+		},
+	}
+	suite := tests.NewMigratorSuite(mm)
+	suite.Drop(t)
+}
+
+func TestMigrate_ChecksumDrift(t *testing.T) {
+	newMemSuite().ChecksumDrift(t)
+}
+
+func TestMigrate_HistoryReplay(t *testing.T) {
+	newMemSuite().HistoryReplay(t)
+}
+
+func TestMigrate_SingleTx(t *testing.T) {
+	newMemSuite().SingleTx(t)
+}
+
+func TestMigrate_Baseline(t *testing.T) {
+	newMemSuite().Baseline(t)
+}
+
+func TestMigrate_ForceVersion(t *testing.T) {
+	newMemSuite().ForceVersion(t)
+}
+
+func TestMigrate_Dirty(t *testing.T) {
+	newMemSuite().Dirty(t)
+}
+
+func TestMigrate_Repeatable(t *testing.T) {
+	newMemSuite().Repeatable(t)
+}
+
+func newMemSuite() *tests.MigratorSuite {
+	m := &tests.MemMigrator{
+		ExecFn: func(ctx context.Context, query string) error {
+			if strings.Contains(query, "no_such_table") {
+				return errors.New("no such table")
+			}
+			return nil
+		},
+	}
+	return tests.NewMigratorSuite(m)
+}
+
+func TestBeforeAfterStep(t *testing.T) {
+	currVersion := 3
+	wantLog := []string{
+		"lockdb", "init", "getversion",
+		"before", "{v:4 q:'SELECT 4;' notx:false}",
+		"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+		"after", "{v:4 q:'SELECT 4;' notx:false}",
+		"before", "{v:5 q:'SELECT 5;' notx:false}",
+		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+		"after", "{v:5 q:'SELECT 5;' notx:false}",
+		"unlockdb",
+	}
+
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return currVersion, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeApplyAll,
+		BeforeStep: func(ctx context.Context, step dbump.Step) {
+			mm.LogAdd("before", fmt.Sprintf("{v:%d q:'%s' notx:%v}", step.Version, step.Query, step.DisableTx))
+		},
+		AfterStep: func(ctx context.Context, step dbump.Step) {
+			mm.LogAdd("after", fmt.Sprintf("{v:%d q:'%s' notx:%v}", step.Version, step.Query, step.DisableTx))
+		},
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestLifecycleHooks(t *testing.T) {
+	errStep := errors.New("no access")
+	wantLog := []string{
+		"lockdb", "onlock", "init", "getversion",
+		"onversion", "3 -> 5",
+		"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+		"onstepdone", "0 <nil>",
+		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+		"onstepdone", "1 no access",
+		"unlockdb", "onunlock",
+		"onfinish", "migration 5 (0005_final.sql) apply: no access",
+	}
+
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 3, nil
+		},
+		DoStepFn: func(ctx context.Context, step dbump.Step) error {
+			if step.Version == 5 {
+				return errStep
+			}
+			return nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeApplyAll,
+		OnLock: func(ctx context.Context) {
+			mm.LogAdd("onlock")
+		},
+		OnUnlock: func(ctx context.Context) {
+			mm.LogAdd("onunlock")
+		},
+		OnVersion: func(ctx context.Context, current, target int) {
+			mm.LogAdd("onversion", fmt.Sprintf("%d -> %d", current, target))
+		},
+		OnStepDone: func(ctx context.Context, step dbump.Step, result dbump.StepResult) {
+			if result.Duration < 0 {
+				t.Errorf("want non-negative duration, got %v", result.Duration)
+			}
+			mm.LogAdd("onstepdone", fmt.Sprintf("%d %v", result.Index, result.Err))
+		},
+		OnFinish: func(ctx context.Context, err error) {
+			mm.LogAdd("onfinish", err.Error())
+		},
+	}
+
+	err := dbump.Run(context.Background(), cfg)
+	if !errors.Is(err, errStep) {
+		t.Fatalf("want %v, got %v", errStep, err)
+	}
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestCheckStep(t *testing.T) {
+	errVeto := errors.New("reverts are not allowed")
+	wantLog := []string{
+		"lockdb", "init", "getversion",
+		"unlockdb",
+	}
+
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 3, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeRevertN,
+		Num:      1,
+		CheckStep: func(ctx context.Context, step dbump.Step) error {
+			if step.Direction == dbump.DirectionRevert {
+				return errVeto
+			}
+			return nil
+		},
+		BeforeStep: func(ctx context.Context, step dbump.Step) {
+			t.Fatal("must not be called")
+		},
+	}
+
+	err := dbump.Run(context.Background(), cfg)
+	if !errors.Is(err, errVeto) {
+		t.Fatalf("want %v, got %v", errVeto, err)
+	}
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "duration" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 4, nil
+		},
+		DoStepFn: func(ctx context.Context, step dbump.Step) error {
+			dbump.Logger(ctx).Info("from migrator")
+			return nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeApplyAll,
+		Logger:   logger,
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+
+	want := `level=INFO msg="database locked" forced=false
+level=INFO msg="versions detected" current=4 target=5
+level=INFO msg="step started" id=5 name=0005_final.sql direction=apply phase=main version=5
+level=INFO msg="from migrator"
+level=INFO msg="step finished" id=5 name=0005_final.sql direction=apply phase=main version=5
+level=INFO msg="database unlocked"
+`
+	mustEqual(t, buf.String(), want)
+}
+
+func TestTimeout(t *testing.T) {
+	wantLog := []string{
+		"lockdb", "init", "getversion",
+		"dostep", "{v:1 q:'SELECT 1;' notx:false}",
+		"unlockdb",
+	}
+
+	mm := &tests.MockMigrator{
+		DoStepFn: func(ctx context.Context, step dbump.Step) error {
+			select {
+			case <-time.After(30 * time.Second):
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeApplyAll,
+		Timeout:  20 * time.Millisecond,
+	}
+
+	failIfOk(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestDisableTx(t *testing.T) {
+	wantLog := []string{
+		"lockdb", "init", "getversion",
+		"dostep", "{v:1 q:'SELECT 1;' notx:true}",
+		"unlockdb",
+	}
+
+	mm := &tests.MockMigrator{}
+	cfg := dbump.Config{
+		Migrator:  mm,
+		Loader:    dbump.NewSliceLoader(testdataMigrations[:1]),
+		Mode:      dbump.ModeApplyAll,
+		DisableTx: true,
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestNoTransactionDirective(t *testing.T) {
+	wantLog := []string{
+		"lockdb", "init", "getversion",
+		"dostep", "{v:1 q:'SELECT 1;' notx:true}",
+		"dostep", "{v:0 q:'SELECT 10;' notx:false}",
+		"dostep", "{v:1 q:'SELECT 1;' notx:true}",
+		"unlockdb",
+	}
+
+	mm := &tests.MockMigrator{}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader: dbump.NewSliceLoader([]*dbump.Migration{
+			{
+				ID:              1,
+				Apply:           "SELECT 1;",
+				Revert:          "SELECT 10;",
+				ApplyDirectives: dbump.Directives{NoTransaction: true},
+			},
+		}),
+		Mode:   dbump.ModeApplyAll,
+		ZigZag: true,
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestIrreversible(t *testing.T) {
+	testCases := []struct {
+		testName string
+		mode     dbump.MigratorMode
+		version  int
+		zigzag   bool
+		wantErr  bool
+		wantLog  []string
+	}{
+		{
+			testName: "apply",
+			mode:     dbump.ModeApplyAll,
+			version:  1,
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"dostep", "{v:2 q:'SELECT 2;' notx:false}",
+				"dostep", "{v:3 q:'SELECT 3;' notx:false}",
+				"unlockdb",
+			},
+		},
+		{
+			testName: "revert above",
+			mode:     dbump.ModeRevertN,
+			version:  3,
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"dostep", "{v:2 q:'SELECT 30;' notx:false}",
+				"unlockdb",
+			},
+		},
+		{
+			testName: "revert one",
+			mode:     dbump.ModeRevertN,
+			version:  2,
+			wantErr:  true,
+			wantLog:  []string{"lockdb", "init", "getversion", "unlockdb"},
+		},
+		{
+			testName: "revert all",
+			mode:     dbump.ModeRevertAll,
+			version:  3,
+			wantErr:  true,
+			wantLog:  []string{"lockdb", "init", "getversion", "unlockdb"},
+		},
+		{
+			testName: "redo",
+			mode:     dbump.ModeRedo,
+			version:  2,
+			wantErr:  true,
+			wantLog:  []string{"lockdb", "init", "getversion", "unlockdb"},
+		},
+		{
+			testName: "drop",
+			mode:     dbump.ModeDrop,
+			version:  3,
+			wantErr:  true,
+			wantLog:  []string{"lockdb", "init", "getversion", "unlockdb"},
+		},
+		{
+			testName: "zigzag",
+			mode:     dbump.ModeApplyAll,
+			version:  1,
+			zigzag:   true,
+			wantErr:  true,
+			wantLog:  []string{"lockdb", "init", "getversion", "unlockdb"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			mm := &tests.MockMigrator{
+				VersionFn: func(ctx context.Context) (version int, err error) {
+					return tc.version, nil
+				},
+			}
+			cfg := dbump.Config{
+				Migrator: mm,
+				Loader: dbump.NewSliceLoader([]*dbump.Migration{
+					{ID: 1, Name: "0001_a.sql", Apply: "SELECT 1;", Revert: "SELECT 10;"},
+					{ID: 2, Name: "0002_b.sql", Apply: "SELECT 2;", Irreversible: true},
+					{ID: 3, Name: "0003_c.sql", Apply: "SELECT 3;", Revert: "SELECT 30;"},
+				}),
+				Mode:   tc.mode,
+				Num:    1,
+				ZigZag: tc.zigzag,
+			}
+
+			err := dbump.Run(context.Background(), cfg)
+			if tc.wantErr {
+				var errIrreversible *dbump.IrreversibleError
+				if !errors.As(err, &errIrreversible) {
+					t.Fatalf("want IrreversibleError, got %v", err)
+				}
+				mustEqual(t, errIrreversible.ID, 2)
+			} else {
+				failIfErr(t, err)
+			}
+			mustEqual(t, mm.Log(), tc.wantLog)
+		})
+	}
+}
+
+func TestSingleTx(t *testing.T) {
+	errStep := errors.New("syntax error")
+
+	testCases := []struct {
+		testName string
+		failAt   int
+		wantLog  []string
+	}{
+		{
+			testName: "commit",
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"begin",
+				"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+				"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+				"commit",
+				"unlockdb",
+			},
+		},
+		{
+			testName: "rollback",
+			failAt:   5,
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"begin",
+				"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+				"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+				"rollback",
+				"unlockdb",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			mm := &tests.MockTxMigrator{
+				MockMigrator: &tests.MockMigrator{
+					VersionFn: func(ctx context.Context) (version int, err error) {
+						return 3, nil
+					},
+					DoStepFn: func(ctx context.Context, step dbump.Step) error {
+						if step.Version == tc.failAt {
+							return errStep
+						}
+						return nil
+					},
+				},
+			}
+			cfg := dbump.Config{
+				Migrator: mm,
+				Loader:   dbump.NewSliceLoader(testdataMigrations),
+				Mode:     dbump.ModeApplyAll,
+				SingleTx: true,
+			}
+
+			err := dbump.Run(context.Background(), cfg)
+			if tc.failAt != 0 && !errors.Is(err, errStep) {
+				t.Fatalf("want %v, got %v", errStep, err)
+			}
+			if tc.failAt == 0 {
+				failIfErr(t, err)
+			}
+			mustEqual(t, mm.Log(), tc.wantLog)
+		})
+	}
+}
+
+func TestSingleTxErrors(t *testing.T) {
+	testCases := []struct {
+		testName string
+		migrator dbump.Migrator
+		loader   dbump.Loader
+	}{
+		{
+			testName: "not supported",
+			migrator: &tests.MockMigrator{},
+			loader:   dbump.NewSliceLoader(testdataMigrations),
+		},
+		{
+			testName: "no-transaction directive",
+			migrator: &tests.MockTxMigrator{MockMigrator: &tests.MockMigrator{}},
+			loader: dbump.NewSliceLoader([]*dbump.Migration{
+				{
+					ID:              1,
+					Apply:           "SELECT 1;",
+					Revert:          "SELECT 10;",
+					ApplyDirectives: dbump.Directives{NoTransaction: true},
+				},
+			}),
+		},
+	}
+
+	for _, tc := range testCases {
+		cfg := dbump.Config{
+			Migrator: tc.migrator,
+			Loader:   tc.loader,
+			Mode:     dbump.ModeApplyAll,
+			SingleTx: true,
+		}
+		failIfOk(t, dbump.Run(context.Background(), cfg))
+
+		_, err := dbump.Plan(context.Background(), cfg)
+		failIfOk(t, err)
+	}
+}
+
+func TestLockless(t *testing.T) {
+	wantLog := []string{
+		"init",
+		"getversion",
+		"dostep", "{v:1 q:'SELECT 1;' notx:false}",
+		"dostep", "{v:2 q:'SELECT 2;' notx:false}",
+		"dostep", "{v:3 q:'SELECT 3;' notx:false}",
+		"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+	}
+
+	mm := &tests.MockMigrator{}
+	cfg := dbump.Config{
+		Migrator:       mm,
+		Loader:         dbump.NewSliceLoader(testdataMigrations),
+		Mode:           dbump.ModeApplyAll,
+		NoDatabaseLock: true,
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestUseForce(t *testing.T) {
+	currVersion := 3
+	wantLog := []string{
+		"lockdb", "unlockdb", "lockdb", "init", "getversion",
+		"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+		"unlockdb",
+	}
+
+	isLocked := true
+
+	mm := &tests.MockMigrator{
+		LockDBFn: func(ctx context.Context) error {
+			if isLocked {
+				return errors.New("cannot get lock")
+			}
+			return nil
+		},
+		UnlockDBFn: func(ctx context.Context) error {
+			isLocked = false
+			return nil
+		},
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return currVersion, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeApplyAll,
+		UseForce: true,
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestLockTimeout(t *testing.T) {
+	wantLog := []string{
+		"lockdb", "lockdb", "lockdb", "init", "getversion",
+		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+		"unlockdb",
+	}
+
+	attempts := 0
+	mm := &tests.MockMigrator{
+		LockDBFn: func(ctx context.Context) error {
+			if attempts++; attempts < 3 {
+				return dbump.ErrMigrationAlreadyLocked
+			}
+			return nil
+		},
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 4, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator:          mm,
+		Loader:            dbump.NewSliceLoader(testdataMigrations),
+		Mode:              dbump.ModeApplyAll,
+		LockTimeout:       time.Second,
+		LockRetryInterval: time.Millisecond,
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestAlreadyLocked(t *testing.T) {
+	testCases := []struct {
+		testName    string
+		lockTimeout time.Duration
+	}{
+		{
+			testName:    "no wait",
+			lockTimeout: 0,
+		},
+		{
+			testName:    "timeout",
+			lockTimeout: 20 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			attempts := 0
+			mm := &tests.MockMigrator{
+				LockDBFn: func(ctx context.Context) error {
+					attempts++
+					return dbump.ErrMigrationAlreadyLocked
+				},
+			}
+			cfg := dbump.Config{
+				Migrator:          mm,
+				Loader:            dbump.NewSliceLoader(testdataMigrations),
+				Mode:              dbump.ModeApplyAll,
+				LockTimeout:       tc.lockTimeout,
+				LockRetryInterval: time.Millisecond,
+			}
+
+			err := dbump.Run(context.Background(), cfg)
+			if !errors.Is(err, dbump.ErrMigrationAlreadyLocked) {
+				t.Fatalf("want ErrMigrationAlreadyLocked, got %v", err)
+			}
+			if tc.lockTimeout == 0 && attempts != 1 {
+				t.Fatalf("want 1 attempt, got %d", attempts)
+			}
+			if tc.lockTimeout != 0 && attempts < 2 {
+				t.Fatalf("want few attempts, got %d", attempts)
+			}
+		})
+	}
+}
+
+func TestZigZag(t *testing.T) {
+	wantLog := []string{
+		"lockdb", "init", "getversion",
+		"dostep", "{v:1 q:'SELECT 1;' notx:false}",
+		"dostep", "{v:0 q:'SELECT 10;' notx:false}",
+		"dostep", "{v:1 q:'SELECT 1;' notx:false}",
+
+		"dostep", "{v:2 q:'SELECT 2;' notx:false}",
+		"dostep", "{v:1 q:'SELECT 20;' notx:false}",
+		"dostep", "{v:2 q:'SELECT 2;' notx:false}",
+
+		"dostep", "{v:3 q:'SELECT 3;' notx:false}",
+		"dostep", "{v:2 q:'SELECT 30;' notx:false}",
+		"dostep", "{v:3 q:'SELECT 3;' notx:false}",
+
+		"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+		"dostep", "{v:3 q:'SELECT 40;' notx:false}",
+		"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+
+		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+		"dostep", "{v:4 q:'SELECT 50;' notx:false}",
+		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+		"unlockdb",
+	}
+
+	mm := &tests.MockMigrator{}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeApplyAll,
+		ZigZag:   true,
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestPlan(t *testing.T) {
+	wantLog := []string{"init", "getversion"}
+	wantSteps := []dbump.Step{
+		{Version: 2, Query: "SELECT 30;", Name: "0003_even-better.sql", Direction: dbump.DirectionRevert, MigrationID: 3, Phase: dbump.PhaseMain},
+		{Version: 1, Query: "SELECT 20;", Name: "0002_another.sql", Direction: dbump.DirectionRevert, MigrationID: 2, Phase: dbump.PhaseMain},
+	}
+
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 3, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeRevertN,
+		Num:      2,
+	}
+
+	plan, err := dbump.Plan(context.Background(), cfg)
+	failIfErr(t, err)
+	mustEqual(t, plan.Steps, wantSteps)
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestPlanRedoEmpty(t *testing.T) {
+	cfg := dbump.Config{
+		Migrator: &tests.MockMigrator{},
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeRedo,
+	}
+
+	_, err := dbump.Plan(context.Background(), cfg)
+	failIfOk(t, err)
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	wantLog := []string{"lockdb", "init", "getversion", "history", "unlockdb"}
+
+	mm := &tests.MockHistoryMigrator{
+		MockMigrator: &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 3, nil
+			},
+		},
+		HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+			return []dbump.LogEntry{
+				{Version: 1, Checksum: testdataMigrations[0].Checksum},
+				{Version: 2, Checksum: "edited"},
+				{Version: 3, Checksum: "edited"},
+				{Version: 2},
+				{Version: 3, Checksum: testdataMigrations[2].Checksum},
+			}, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeApplyAll,
+	}
+
+	err := dbump.Run(context.Background(), cfg)
+
+	var errChecksum *dbump.ChecksumError
+	if !errors.As(err, &errChecksum) {
+		t.Fatalf("want ChecksumError, got %v", err)
+	}
+	mustEqual(t, errChecksum.Migrations, []*dbump.Migration{testdataMigrations[1]})
+	mustEqual(t, err.Error(), "checksum mismatch for applied migrations: 0002_another.sql")
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestRepairChecksums(t *testing.T) {
+	wantLog := []string{
+		"lockdb", "init", "getversion", "history",
+		"setchecksum", "{v:1 c:" + testdataMigrations[0].Checksum + "}",
+		"setchecksum", "{v:2 c:" + testdataMigrations[1].Checksum + "}",
+		"unlockdb",
+	}
+
+	mm := &tests.MockHistoryMigrator{
+		MockMigrator: &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 3, nil
+			},
+		},
+		HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+			return []dbump.LogEntry{
+				{Version: 1},
+				{Version: 2, Checksum: "edited"},
+				{Version: 3, Checksum: testdataMigrations[2].Checksum},
+			}, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeRepairChecksums,
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestPlanRepairChecksums(t *testing.T) {
+	mm := &tests.MockHistoryMigrator{
+		MockMigrator: &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 2, nil
+			},
+		},
+		HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+			return []dbump.LogEntry{
+				{Version: 1, Checksum: testdataMigrations[0].Checksum},
+				{Version: 2, Checksum: "edited"},
+			}, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeRepairChecksums,
+	}
+
+	plan, err := dbump.Plan(context.Background(), cfg)
+	failIfErr(t, err)
+	mustEqual(t, len(plan.Steps), 0)
+	mustEqual(t, len(plan.Repairs), 1)
+	mustEqual(t, plan.Repairs[0].ID, 2)
+	mustEqual(t, mm.Log(), []string{"init", "getversion", "history"})
+}
+
+func TestRepairChecksumsNotSupported(t *testing.T) {
+	cfg := dbump.Config{
+		Migrator: &tests.MockMigrator{},
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeRepairChecksums,
+	}
+	failIfOk(t, dbump.Run(context.Background(), cfg))
+}
+
+func TestOutOfOrder(t *testing.T) {
+	history := []dbump.LogEntry{
+		{Version: 1},
+		{Version: 3, MigrationID: 3, Direction: dbump.DirectionApply},
+	}
+
+	testCases := []struct {
+		testName string
+		mode     dbump.MigratorMode
+		wantLog  []string
+	}{
+		{
+			testName: "apply all",
+			mode:     dbump.ModeApplyAll,
+			wantLog: []string{
+				"lockdb", "init", "getversion", "history",
+				"dostep", "{v:3 q:'SELECT 2;' notx:false}",
+				"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+				"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+				"unlockdb",
+			},
+		},
+		{
+			testName: "revert all",
+			mode:     dbump.ModeRevertAll,
+			wantLog: []string{
+				"lockdb", "init", "getversion", "history",
+				"dostep", "{v:2 q:'SELECT 30;' notx:false}",
+				"dostep", "{v:1 q:'' notx:false}",
+				"dostep", "{v:0 q:'SELECT 10;' notx:false}",
+				"unlockdb",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		mm := &tests.MockHistoryMigrator{
+			MockMigrator: &tests.MockMigrator{
+				VersionFn: func(ctx context.Context) (version int, err error) {
+					return 3, nil
+				},
+			},
+			HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+				return history, nil
+			},
+		}
+		cfg := dbump.Config{
+			Migrator:        mm,
+			Loader:          dbump.NewSliceLoader(testdataMigrations),
+			Mode:            tc.mode,
+			AllowOutOfOrder: true,
+		}
+
+		failIfErr(t, dbump.Run(context.Background(), cfg))
+		mustEqual(t, mm.Log(), tc.wantLog)
+	}
+}
+
+func TestOutOfOrderRevertNotApplied(t *testing.T) {
+	mm := &tests.MockHistoryMigrator{
+		MockMigrator: &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 2, nil
+			},
+		},
+		HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+			return []dbump.LogEntry{
+				{Version: 2, MigrationID: 2, Direction: dbump.DirectionApply},
+			}, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator:        mm,
+		Loader:          dbump.NewSliceLoader(testdataMigrations),
+		Mode:            dbump.ModeRevertAll,
+		AllowOutOfOrder: true,
+	}
+
+	wantLog := []string{
+		"lockdb", "init", "getversion", "history",
+		"dostep", "{v:1 q:'SELECT 20;' notx:false}",
+		"dostep", "{v:0 q:'' notx:false}",
+		"unlockdb",
+	}
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestOutOfOrderRevertN(t *testing.T) {
+	ctx := context.Background()
+	mm := &tests.MemMigrator{}
+	migs := []*dbump.Migration{
+		{ID: 1, Apply: "SELECT 1;", Revert: "SELECT 10;"},
+		{ID: 2, Apply: "SELECT 2;", Revert: "SELECT 20;"},
+		{ID: 3, Apply: "SELECT 3;", Revert: "SELECT 30;"},
+	}
 
-	suite.RevertAllWhenEmpty(t)
+	// migration 2 is not applied.
+	failIfErr(t, dbump.Run(ctx, dbump.Config{
+		Migrator:  mm,
+		Loader:    dbump.NewSliceLoader([]*dbump.Migration{migs[0], migs[2]}),
+		Mode:      dbump.ModeApplyAll,
+		SparseIDs: true,
+	}))
+
+	cfg := dbump.Config{
+		Migrator:        mm,
+		Loader:          dbump.NewSliceLoader(migs),
+		Mode:            dbump.ModeRevertN,
+		Num:             1,
+		AllowOutOfOrder: true,
+	}
+	for _, want := range []int{2, 1, 0} {
+		failIfErr(t, dbump.Run(ctx, cfg))
+		version, err := mm.Version(ctx)
+		failIfErr(t, err)
+		mustEqual(t, version, want)
+	}
 }
 
-func TestMigrate_RevertAll(t *testing.T) {
-	mm := &tests.MockMigrator{
-		VersionFn: func(ctx context.Context) (version int, err error) {
-			return 5, nil
+func TestOutOfOrderIrreversible(t *testing.T) {
+	mm := &tests.MockHistoryMigrator{
+		MockMigrator: &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 3, nil
+			},
+		},
+		HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+			return []dbump.LogEntry{
+				{Version: 1, MigrationID: 1, Direction: dbump.DirectionApply},
+				{Version: 3, MigrationID: 3, Direction: dbump.DirectionApply},
+			}, nil
 		},
 	}
-	suite := tests.NewMigratorSuite(mm)
-	suite.RevertAll(t)
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader: dbump.NewSliceLoader([]*dbump.Migration{
+			{ID: 1, Name: "0001_a.sql", Apply: "SELECT 1;", Revert: "SELECT 10;"},
+			{ID: 2, Name: "0002_b.sql", Apply: "SELECT 2;", Irreversible: true},
+			{ID: 3, Name: "0003_c.sql", Apply: "SELECT 3;", Revert: "SELECT 30;"},
+		}),
+		Mode:            dbump.ModeApplyAll,
+		AllowOutOfOrder: true,
+		ZigZag:          true,
+	}
+
+	var errIrreversible *dbump.IrreversibleError
+	if err := dbump.Run(context.Background(), cfg); !errors.As(err, &errIrreversible) {
+		t.Fatalf("want IrreversibleError, got %v", err)
+	}
+	mustEqual(t, errIrreversible.ID, 2)
+	mustEqual(t, mm.Log(), []string{"lockdb", "init", "getversion", "history", "unlockdb"})
+
+	// not applied migration is not touched by revert.
+	cfg.Mode, cfg.Num = dbump.ModeRevertN, 1
+	plan, err := dbump.Plan(context.Background(), cfg)
+	failIfErr(t, err)
+	mustEqual(t, len(plan.Steps), 3)
+	mustEqual(t, plan.Steps[0].MigrationID, 3)
 }
 
-func TestMigrate_Redo(t *testing.T) {
-	mm := &tests.MockMigrator{
-		VersionFn: func(ctx context.Context) (version int, err error) {
-			return 3, nil
-		},
+func TestOutOfOrderNotSupported(t *testing.T) {
+	cfg := dbump.Config{
+		Migrator:        &tests.MockMigrator{},
+		Loader:          dbump.NewSliceLoader(testdataMigrations),
+		Mode:            dbump.ModeApplyAll,
+		AllowOutOfOrder: true,
 	}
-	suite := tests.NewMigratorSuite(mm)
-	suite.Redo(t)
+	failIfOk(t, dbump.Run(context.Background(), cfg))
 }
 
-func TestMigrate_Drop(t *testing.T) {
-	mm := &tests.MockMigrator{
-		VersionFn: func(ctx context.Context) (version int, err error) {
-			return 5, nil
+func TestBaseline(t *testing.T) {
+	testCases := []struct {
+		testName string
+		history  []dbump.LogEntry
+		force    bool
+		useForce bool
+		wantErr  bool
+		wantLog  []string
+	}{
+		{
+			testName: "empty log",
+			wantLog: []string{
+				"lockdb", "init", "history",
+				"setversion", "{v:3 r:baseline}",
+				"unlockdb",
+			},
+		},
+		{
+			testName: "existing log",
+			history:  []dbump.LogEntry{{Version: 1}},
+			wantErr:  true,
+			wantLog:  []string{"lockdb", "init", "history", "unlockdb"},
+		},
+		{
+			testName: "existing log with lock force",
+			history:  []dbump.LogEntry{{Version: 1}},
+			useForce: true,
+			wantErr:  true,
+			wantLog:  []string{"lockdb", "init", "history", "unlockdb"},
+		},
+		{
+			testName: "existing log with force",
+			history:  []dbump.LogEntry{{Version: 1}},
+			force:    true,
+			wantLog: []string{
+				"lockdb", "init", "history",
+				"setversion", "{v:3 r:baseline}",
+				"unlockdb",
+			},
 		},
 	}
-	suite := tests.NewMigratorSuite(mm)
-	suite.Drop(t)
-}
 
-func TestBeforeAfterStep(t *testing.T) {
-	currVersion := 3
-	wantLog := []string{
-		"lockdb", "init", "getversion",
-		"before", "{v:4 q:'SELECT 4;' notx:false}",
-		"dostep", "{v:4 q:'SELECT 4;' notx:false}",
-		"after", "{v:4 q:'SELECT 4;' notx:false}",
-		"before", "{v:5 q:'SELECT 5;' notx:false}",
-		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
-		"after", "{v:5 q:'SELECT 5;' notx:false}",
-		"unlockdb",
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			mm := &tests.MockHistoryMigrator{
+				MockMigrator: &tests.MockMigrator{},
+				HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+					return tc.history, nil
+				},
+			}
+			cfg := dbump.Config{
+				Migrator:      mm,
+				Loader:        dbump.NewSliceLoader(testdataMigrations),
+				Mode:          dbump.ModeBaseline,
+				Version:       3,
+				BaselineForce: tc.force,
+				UseForce:      tc.useForce,
+			}
+
+			err := dbump.Run(context.Background(), cfg)
+			if tc.wantErr {
+				failIfOk(t, err)
+			} else {
+				failIfErr(t, err)
+			}
+			mustEqual(t, mm.Log(), tc.wantLog)
+		})
 	}
+}
 
-	mm := &tests.MockMigrator{
-		VersionFn: func(ctx context.Context) (version int, err error) {
-			return currVersion, nil
+func TestBaselineErrors(t *testing.T) {
+	testCases := []struct {
+		testName string
+		migrator dbump.Migrator
+		version  int
+	}{
+		{
+			testName: "not supported",
+			migrator: &tests.MockMigrator{},
+			version:  3,
 		},
-	}
-	cfg := dbump.Config{
-		Migrator: mm,
-		Loader:   dbump.NewSliceLoader(testdataMigrations),
-		Mode:     dbump.ModeApplyAll,
-		BeforeStep: func(ctx context.Context, step dbump.Step) {
-			mm.LogAdd("before", fmt.Sprintf("{v:%d q:'%s' notx:%v}", step.Version, step.Query, step.DisableTx))
+		{
+			testName: "unknown version",
+			migrator: &tests.MockHistoryMigrator{MockMigrator: &tests.MockMigrator{}},
+			version:  10,
 		},
-		AfterStep: func(ctx context.Context, step dbump.Step) {
-			mm.LogAdd("after", fmt.Sprintf("{v:%d q:'%s' notx:%v}", step.Version, step.Query, step.DisableTx))
+		{
+			testName: "zero version",
+			migrator: &tests.MockHistoryMigrator{MockMigrator: &tests.MockMigrator{}},
+			version:  0,
+		},
+		{
+			testName: "has log",
+			migrator: &tests.MockHistoryMigrator{
+				MockMigrator: &tests.MockMigrator{},
+				HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+					return []dbump.LogEntry{{Version: 1}}, nil
+				},
+			},
+			version: 3,
 		},
 	}
 
-	failIfErr(t, dbump.Run(context.Background(), cfg))
-	mustEqual(t, mm.Log(), wantLog)
+	for _, tc := range testCases {
+		cfg := dbump.Config{
+			Migrator: tc.migrator,
+			Loader:   dbump.NewSliceLoader(testdataMigrations),
+			Mode:     dbump.ModeBaseline,
+			Version:  tc.version,
+		}
+		failIfOk(t, dbump.Run(context.Background(), cfg))
+
+		_, err := dbump.Plan(context.Background(), cfg)
+		failIfOk(t, err)
+	}
 }
 
-func TestTimeout(t *testing.T) {
+func TestForceVersion(t *testing.T) {
 	wantLog := []string{
-		"lockdb", "init", "getversion",
-		"dostep", "{v:1 q:'SELECT 1;' notx:false}",
+		"lockdb", "init",
+		"setversion", "{v:2 r:failed 0003 without tx}",
 		"unlockdb",
 	}
 
-	mm := &tests.MockMigrator{
-		DoStepFn: func(ctx context.Context, step dbump.Step) error {
-			select {
-			case <-time.After(30 * time.Second):
-				return nil
-			case <-ctx.Done():
-				return ctx.Err()
-			}
-		},
+	mm := &tests.MockHistoryMigrator{
+		MockMigrator: &tests.MockMigrator{},
 	}
 	cfg := dbump.Config{
 		Migrator: mm,
 		Loader:   dbump.NewSliceLoader(testdataMigrations),
-		Mode:     dbump.ModeApplyAll,
-		Timeout:  20 * time.Millisecond,
+		Mode:     dbump.ModeForceVersion,
+		Version:  2,
+		Reason:   "failed 0003 without tx",
 	}
 
-	failIfOk(t, dbump.Run(context.Background(), cfg))
+	failIfErr(t, dbump.Run(context.Background(), cfg))
 	mustEqual(t, mm.Log(), wantLog)
 }
 
-func TestDisableTx(t *testing.T) {
-	wantLog := []string{
-		"lockdb", "init", "getversion",
-		"dostep", "{v:1 q:'SELECT 1;' notx:true}",
-		"unlockdb",
+func TestForceVersionHistory(t *testing.T) {
+	mm := &tests.MockHistoryMigrator{
+		MockMigrator: &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 2, nil
+			},
+		},
+		HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+			return []dbump.LogEntry{
+				{Version: 1, MigrationID: 1, Direction: dbump.DirectionApply},
+				{Version: 2, MigrationID: 2, Direction: dbump.DirectionApply},
+				{Version: 3, MigrationID: 3, Direction: dbump.DirectionApply},
+				{Version: 2, Reason: "failed 0003 without tx"},
+			}, nil
+		},
 	}
 
-	mm := &tests.MockMigrator{}
-	cfg := dbump.Config{
-		Migrator:  mm,
-		Loader:    dbump.NewSliceLoader(testdataMigrations[:1]),
-		Mode:      dbump.ModeApplyAll,
-		DisableTx: true,
-	}
+	report, err := dbump.Status(context.Background(), mm, dbump.NewSliceLoader(testdataMigrations))
+	failIfErr(t, err)
 
-	failIfErr(t, dbump.Run(context.Background(), cfg))
-	mustEqual(t, mm.Log(), wantLog)
+	var applied []int
+	for _, ms := range report.Migrations {
+		if ms.Applied {
+			applied = append(applied, ms.Migration.ID)
+		}
+	}
+	mustEqual(t, applied, []int{1, 2})
 }
 
-func TestLockless(t *testing.T) {
-	wantLog := []string{
-		"init",
-		"getversion",
-		"dostep", "{v:1 q:'SELECT 1;' notx:false}",
-		"dostep", "{v:2 q:'SELECT 2;' notx:false}",
-		"dostep", "{v:3 q:'SELECT 3;' notx:false}",
-		"dostep", "{v:4 q:'SELECT 4;' notx:false}",
-		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+func TestDirty(t *testing.T) {
+	dirty := &dbump.LogEntry{
+		Version:     3,
+		MigrationID: 3,
+		Name:        "0003_index.sql",
+		Direction:   dbump.DirectionApply,
 	}
 
-	mm := &tests.MockMigrator{}
-	cfg := dbump.Config{
-		Migrator:       mm,
-		Loader:         dbump.NewSliceLoader(testdataMigrations),
-		Mode:           dbump.ModeApplyAll,
-		NoDatabaseLock: true,
+	testCases := []struct {
+		testName string
+		mode     dbump.MigratorMode
+		wantErr  bool
+		wantLog  []string
+	}{
+		{
+			testName: "apply all",
+			mode:     dbump.ModeApplyAll,
+			wantErr:  true,
+			wantLog:  []string{"lockdb", "init", "dirty", "unlockdb"},
+		},
+		{
+			testName: "force version",
+			mode:     dbump.ModeForceVersion,
+			wantLog: []string{
+				"lockdb", "init",
+				"setversion", "{v:2 r:index dropped}",
+				"unlockdb",
+			},
+		},
 	}
 
-	failIfErr(t, dbump.Run(context.Background(), cfg))
-	mustEqual(t, mm.Log(), wantLog)
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			mm := &tests.MockDirtyMigrator{
+				MockHistoryMigrator: &tests.MockHistoryMigrator{
+					MockMigrator: &tests.MockMigrator{},
+				},
+				DirtyFn: func(ctx context.Context) (*dbump.LogEntry, error) {
+					return dirty, nil
+				},
+			}
+			cfg := dbump.Config{
+				Migrator: mm,
+				Loader:   dbump.NewSliceLoader(testdataMigrations),
+				Mode:     tc.mode,
+				Version:  2,
+				Reason:   "index dropped",
+			}
+
+			err := dbump.Run(context.Background(), cfg)
+			if tc.wantErr {
+				var errDirty *dbump.DirtyError
+				if !errors.As(err, &errDirty) {
+					t.Fatalf("want DirtyError, got %v", err)
+				}
+				mustEqual(t, errDirty.Entry, *dirty)
+				mustEqual(t, err.Error(), "database is dirty: migration 3 (0003_index.sql) apply was started but not finished")
+			} else {
+				failIfErr(t, err)
+			}
+			mustEqual(t, mm.Log(), tc.wantLog)
+		})
+	}
 }
 
-func TestUseForce(t *testing.T) {
-	currVersion := 3
+func TestBaselineOutOfOrder(t *testing.T) {
 	wantLog := []string{
-		"lockdb", "unlockdb", "lockdb", "init", "getversion",
+		"lockdb", "init", "getversion", "history",
 		"dostep", "{v:4 q:'SELECT 4;' notx:false}",
 		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
 		"unlockdb",
 	}
 
-	isLocked := true
-
-	mm := &tests.MockMigrator{
-		LockDBFn: func(ctx context.Context) error {
-			if isLocked {
-				return errors.New("cannot get lock")
-			}
-			return nil
-		},
-		UnlockDBFn: func(ctx context.Context) error {
-			isLocked = false
-			return nil
+	mm := &tests.MockHistoryMigrator{
+		MockMigrator: &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 3, nil
+			},
 		},
-		VersionFn: func(ctx context.Context) (version int, err error) {
-			return currVersion, nil
+		HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+			return []dbump.LogEntry{{Version: 3, Reason: "baseline"}}, nil
 		},
 	}
 	cfg := dbump.Config{
-		Migrator: mm,
-		Loader:   dbump.NewSliceLoader(testdataMigrations),
-		Mode:     dbump.ModeApplyAll,
-		UseForce: true,
+		Migrator:        mm,
+		Loader:          dbump.NewSliceLoader(testdataMigrations),
+		Mode:            dbump.ModeApplyAll,
+		AllowOutOfOrder: true,
 	}
 
 	failIfErr(t, dbump.Run(context.Background(), cfg))
 	mustEqual(t, mm.Log(), wantLog)
 }
 
-func TestZigZag(t *testing.T) {
+func TestFuncMigration(t *testing.T) {
 	wantLog := []string{
 		"lockdb", "init", "getversion",
-		"dostep", "{v:1 q:'SELECT 1;' notx:false}",
-		"dostep", "{v:0 q:'SELECT 10;' notx:false}",
-		"dostep", "{v:1 q:'SELECT 1;' notx:false}",
-
-		"dostep", "{v:2 q:'SELECT 2;' notx:false}",
-		"dostep", "{v:1 q:'SELECT 20;' notx:false}",
-		"dostep", "{v:2 q:'SELECT 2;' notx:false}",
-
-		"dostep", "{v:3 q:'SELECT 3;' notx:false}",
-		"dostep", "{v:2 q:'SELECT 30;' notx:false}",
-		"dostep", "{v:3 q:'SELECT 3;' notx:false}",
-
-		"dostep", "{v:4 q:'SELECT 4;' notx:false}",
-		"dostep", "{v:3 q:'SELECT 40;' notx:false}",
-		"dostep", "{v:4 q:'SELECT 4;' notx:false}",
-
-		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
-		"dostep", "{v:4 q:'SELECT 50;' notx:false}",
-		"dostep", "{v:5 q:'SELECT 5;' notx:false}",
+		"dostep", "{v:1 q:'' notx:false}",
+		"exec", "INSERT 1",
+		"dostep", "{v:0 q:'' notx:false}",
+		"exec", "DELETE 1",
+		"dostep", "{v:1 q:'' notx:false}",
+		"exec", "INSERT 1",
 		"unlockdb",
 	}
 
+	loader := dbump.NewSliceLoader(nil)
+	loader.AddFuncMigration(1, "0001_backfill.go",
+		func(ctx context.Context, exec dbump.Executor) error {
+			return exec.Exec(ctx, "INSERT 1")
+		},
+		func(ctx context.Context, exec dbump.Executor) error {
+			return exec.Exec(ctx, "DELETE 1")
+		},
+	)
+
 	mm := &tests.MockMigrator{}
+	mm.DoStepFn = func(ctx context.Context, step dbump.Step) error {
+		return step.Func(ctx, &mockExecutor{mm: mm})
+	}
 	cfg := dbump.Config{
 		Migrator: mm,
-		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Loader:   loader,
 		Mode:     dbump.ModeApplyAll,
 		ZigZag:   true,
 	}
@@ -322,6 +1614,42 @@ func TestZigZag(t *testing.T) {
 	mustEqual(t, mm.Log(), wantLog)
 }
 
+func TestZigZagPhases(t *testing.T) {
+	type stepInfo struct {
+		Version   int
+		Direction dbump.Direction
+		Phase     dbump.Phase
+	}
+	want := []stepInfo{
+		{4, dbump.DirectionApply, dbump.PhaseMain},
+		{3, dbump.DirectionRevert, dbump.PhaseZigZag},
+		{4, dbump.DirectionApply, dbump.PhaseZigZag},
+		{5, dbump.DirectionApply, dbump.PhaseMain},
+		{4, dbump.DirectionRevert, dbump.PhaseZigZag},
+		{5, dbump.DirectionApply, dbump.PhaseZigZag},
+	}
+
+	cfg := dbump.Config{
+		Migrator: &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 3, nil
+			},
+		},
+		Loader: dbump.NewSliceLoader(testdataMigrations),
+		Mode:   dbump.ModeApplyAll,
+		ZigZag: true,
+	}
+
+	plan, err := dbump.Plan(context.Background(), cfg)
+	failIfErr(t, err)
+
+	var got []stepInfo
+	for _, step := range plan.Steps {
+		got = append(got, stepInfo{step.Version, step.Direction, step.Phase})
+	}
+	mustEqual(t, got, want)
+}
+
 func TestFailOnInitError(t *testing.T) {
 	wantLog := []string{"lockdb", "init", "unlockdb"}
 	mm := &tests.MockMigrator{
@@ -421,6 +1749,72 @@ func TestFailOnDoStepError(t *testing.T) {
 	mustEqual(t, mm.Log(), wantLog)
 }
 
+func TestMigrationError(t *testing.T) {
+	errStep := errors.New("syntax error")
+
+	testCases := []struct {
+		testName    string
+		redactQuery bool
+		want        *dbump.MigrationError
+	}{
+		{
+			testName: "with query",
+			want: &dbump.MigrationError{
+				ID:        5,
+				Name:      "0005_final.sql",
+				Direction: dbump.DirectionApply,
+				StepIndex: 1,
+				Query:     "SELECT 5;",
+				Statement: "SELECT 5",
+			},
+		},
+		{
+			testName:    "redacted",
+			redactQuery: true,
+			want: &dbump.MigrationError{
+				ID:        5,
+				Name:      "0005_final.sql",
+				Direction: dbump.DirectionApply,
+				StepIndex: 1,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		mm := &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 3, nil
+			},
+			DoStepFn: func(ctx context.Context, step dbump.Step) error {
+				if step.Version != 5 {
+					return nil
+				}
+				return dbump.ExecStatements(ctx, step.Query, func(ctx context.Context, stmt string) error {
+					return errStep
+				})
+			},
+		}
+		cfg := dbump.Config{
+			Migrator:    mm,
+			Loader:      dbump.NewSliceLoader(testdataMigrations),
+			Mode:        dbump.ModeApplyAll,
+			RedactQuery: tc.redactQuery,
+		}
+
+		err := dbump.Run(context.Background(), cfg)
+
+		var errMig *dbump.MigrationError
+		if !errors.As(err, &errMig) {
+			t.Fatalf("%s: want MigrationError, got %v", tc.testName, err)
+		}
+		mustEqual(t, errors.Is(err, errStep), true)
+		mustEqual(t, err.Error(), "migration 5 (0005_final.sql) apply: statement 1: syntax error")
+
+		tc.want.Err = errMig.Err
+		mustEqual(t, errMig, tc.want)
+	}
+}
+
 func TestFailOnLoad(t *testing.T) {
 	cfg := dbump.Config{
 		Migrator: &tests.MockMigrator{},
@@ -449,6 +1843,15 @@ func TestLoad(t *testing.T) {
 			errors.New("load: missing migration number: 2 (have 3)"),
 		},
 
+		{
+			"fail (sparse ids)",
+			[]*dbump.Migration{
+				{ID: 20221016120000},
+				{ID: 20221017090000},
+			},
+			errors.New("load: missing migration number: 1 (have 20221016120000)"),
+		},
+
 		{
 			"fail (duplicate id)",
 			[]*dbump.Migration{
@@ -480,6 +1883,20 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+type mockExecutor struct {
+	mm *tests.MockMigrator
+}
+
+func (me *mockExecutor) Exec(ctx context.Context, query string, args ...interface{}) error {
+	me.mm.LogAdd("exec", query)
+	return nil
+}
+
+func (me *mockExecutor) Query(ctx context.Context, query string, args ...interface{}) (dbump.Rows, error) {
+	me.mm.LogAdd("query", query)
+	return nil, errors.New("not implemented")
+}
+
 type MockLoader struct {
 	LoaderFn func() ([]*dbump.Migration, error)
 }
@@ -490,34 +1907,39 @@ func (ml *MockLoader) Load() ([]*dbump.Migration, error) {
 
 var testdataMigrations = []*dbump.Migration{
 	{
-		ID:     1,
-		Name:   `0001_init.sql`,
-		Apply:  `SELECT 1;`,
-		Revert: `SELECT 10;`,
+		ID:       1,
+		Name:     `0001_init.sql`,
+		Apply:    `SELECT 1;`,
+		Revert:   `SELECT 10;`,
+		Checksum: `dcdd5daba4209d61e9f246eee5951f380def2728440ed02d37ea23f92376325d`,
 	},
 	{
-		ID:     2,
-		Name:   `0002_another.sql`,
-		Apply:  `SELECT 2;`,
-		Revert: `SELECT 20;`,
+		ID:       2,
+		Name:     `0002_another.sql`,
+		Apply:    `SELECT 2;`,
+		Revert:   `SELECT 20;`,
+		Checksum: `3ce6080cd19cbbeddb67e040b5fe0ba4e1efed7e2808cc38fb275d60bc5f45e0`,
 	},
 	{
-		ID:     3,
-		Name:   `0003_even-better.sql`,
-		Apply:  `SELECT 3;`,
-		Revert: `SELECT 30;`,
+		ID:       3,
+		Name:     `0003_even-better.sql`,
+		Apply:    `SELECT 3;`,
+		Revert:   `SELECT 30;`,
+		Checksum: `54c5391eb539b42448bd50046e7c0367a59b532cdbc887413afeadbdafe76c12`,
 	},
 	{
-		ID:     4,
-		Name:   `0004_but_fix.sql`,
-		Apply:  `SELECT 4;`,
-		Revert: `SELECT 40;`,
+		ID:       4,
+		Name:     `0004_but_fix.sql`,
+		Apply:    `SELECT 4;`,
+		Revert:   `SELECT 40;`,
+		Checksum: `14b9150d81522b5ab2fe6685535b751a27bc641977afdf8e059b9eee0e10fd46`,
 	},
 	{
-		ID:     5,
-		Name:   `0005_final.sql`,
-		Apply:  `SELECT 5;`,
-		Revert: `SELECT 50;`,
+		ID:       5,
+		Name:     `0005_final.sql`,
+		Apply:    `SELECT 5;`,
+		Revert:   `SELECT 50;`,
+		Checksum: `1b137a0f9f0ebcfde3345f885c2663b62e9819f38796b94c5bf62c7b1257c0ef`,
 	},
 }
 
@@ -0,0 +1,173 @@
+package dbump
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// labelExpr is a parsed Config.Labels expression, reports whether labels match it.
+type labelExpr func(labels []string) bool
+
+// parseLabelExpr parses expressions like `dev`, `!prod`, `dev, test` or `prod and (eu or us)`.
+// Comma is the same as `or`, `!` is the same as `not`.
+func parseLabelExpr(s string) (labelExpr, error) {
+	p := &labelParser{tokens: tokenizeLabelExpr(s)}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %q", tok)
+	}
+	return expr, nil
+}
+
+func tokenizeLabelExpr(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '(' || c == ')' || c == '!' || c == ',':
+			tokens = append(tokens, s[i:i+1])
+			i++
+		default:
+			j := i + 1
+			for j < len(s) && isLabelChar(s[j]) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+type labelParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *labelParser) peek() (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *labelParser) parseOr() (labelExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || (tok != "," && !strings.EqualFold(tok, "or")) {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(labels []string) bool { return l(labels) || right(labels) }
+	}
+}
+
+func (p *labelParser) parseAnd() (labelExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, ok := p.peek()
+		if !ok || !strings.EqualFold(tok, "and") {
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(labels []string) bool { return l(labels) && right(labels) }
+	}
+}
+
+func (p *labelParser) parseNot() (labelExpr, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, errors.New("unexpected end of expression")
+	}
+	p.pos++
+
+	switch {
+	case tok == "!" || strings.EqualFold(tok, "not"):
+		expr, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(labels []string) bool { return !expr(labels) }, nil
+
+	case tok == "(":
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok, ok := p.peek(); !ok || tok != ")" {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+
+	case strings.EqualFold(tok, "and") || strings.EqualFold(tok, "or") || !isLabel(tok):
+		return nil, fmt.Errorf("unexpected %q", tok)
+
+	default:
+		return func(labels []string) bool { return slices.Contains(labels, tok) }, nil
+	}
+}
+
+// parseLabels from a comma separated list like `dev, test`.
+func parseLabels(s string) ([]string, error) {
+	var labels []string
+	for _, label := range strings.Split(s, ",") {
+		label = strings.TrimSpace(label)
+		if !isLabel(label) {
+			return nil, fmt.Errorf("invalid label: %q", label)
+		}
+		labels = append(labels, label)
+	}
+	return labels, nil
+}
+
+// nameLabels returns labels from the migration name like `0003_seed@dev,test.sql`.
+func nameLabels(name string) ([]string, error) {
+	name = strings.TrimSuffix(name, ".sql")
+	_, labels, ok := strings.Cut(name, "@")
+	if !ok {
+		return nil, nil
+	}
+	return parseLabels(labels)
+}
+
+func isLabel(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isLabelChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isLabelChar(c byte) bool {
+	return c == '_' || c == '-' || c == '.' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
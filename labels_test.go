@@ -0,0 +1,234 @@
+package dbump_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/cristalhq/dbump"
+	"github.com/cristalhq/dbump/tests"
+)
+
+func TestLabels(t *testing.T) {
+	testCases := []struct {
+		testName string
+		labels   string
+		wantLog  []string
+	}{
+		{
+			testName: "no labels",
+			labels:   "",
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"dostep", "{v:1 q:'SELECT 1;' notx:false}",
+				"dostep", "{v:2 q:'SELECT 2;' notx:false}",
+				"dostep", "{v:3 q:'SELECT 3;' notx:false}",
+				"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+				"unlockdb",
+			},
+		},
+		{
+			testName: "dev",
+			labels:   "dev",
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"dostep", "{v:1 q:'SELECT 1;' notx:false}",
+				"dostep", "{v:2 q:'SELECT 2;' notx:false}",
+				"dostep", "{v:3 q:'' notx:false}",
+				"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+				"unlockdb",
+			},
+		},
+		{
+			testName: "not dev",
+			labels:   "!dev",
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"dostep", "{v:1 q:'SELECT 1;' notx:false}",
+				"dostep", "{v:2 q:'' notx:false}",
+				"dostep", "{v:3 q:'SELECT 3;' notx:false}",
+				"dostep", "{v:4 q:'' notx:false}",
+				"unlockdb",
+			},
+		},
+		{
+			testName: "expression",
+			labels:   "dev and (eu, us)",
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"dostep", "{v:1 q:'SELECT 1;' notx:false}",
+				"dostep", "{v:2 q:'' notx:false}",
+				"dostep", "{v:3 q:'' notx:false}",
+				"dostep", "{v:4 q:'SELECT 4;' notx:false}",
+				"unlockdb",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			mm := &tests.MockMigrator{}
+			cfg := dbump.Config{
+				Migrator: mm,
+				Loader: dbump.NewSliceLoader([]*dbump.Migration{
+					{ID: 1, Apply: "SELECT 1;"},
+					{ID: 2, Apply: "SELECT 2;", Labels: []string{"dev"}},
+					{ID: 3, Apply: "SELECT 3;", Labels: []string{"prod"}},
+					{ID: 4, Apply: "SELECT 4;", Labels: []string{"dev", "eu"}},
+				}),
+				Mode:   dbump.ModeApplyAll,
+				Labels: tc.labels,
+			}
+
+			failIfErr(t, dbump.Run(context.Background(), cfg))
+			mustEqual(t, mm.Log(), tc.wantLog)
+		})
+	}
+}
+
+func TestLabelsRevert(t *testing.T) {
+	wantLog := []string{
+		"lockdb", "init", "getversion",
+		"dostep", "{v:2 q:'SELECT 30;' notx:false}",
+		"dostep", "{v:1 q:'' notx:false}",
+		"dostep", "{v:0 q:'SELECT 10;' notx:false}",
+		"unlockdb",
+	}
+
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 3, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader: dbump.NewSliceLoader([]*dbump.Migration{
+			{ID: 1, Apply: "SELECT 1;", Revert: "SELECT 10;"},
+			{ID: 2, Apply: "SELECT 2;", Revert: "SELECT 20;", Labels: []string{"dev"}, Irreversible: true},
+			{ID: 3, Apply: "SELECT 3;", Revert: "SELECT 30;"},
+		}),
+		Mode:   dbump.ModeRevertAll,
+		Labels: "prod",
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestLabelsRevertFirstSkipped(t *testing.T) {
+	wantLog := []string{
+		"lockdb", "init", "getversion",
+		"dostep", "{v:2 q:'SELECT 30;' notx:false}",
+		"dostep", "{v:1 q:'SELECT 20;' notx:false}",
+		"dostep", "{v:0 q:'' notx:false}",
+		"unlockdb",
+	}
+
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 3, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader: dbump.NewSliceLoader([]*dbump.Migration{
+			{ID: 1, Apply: "SELECT 1;", Revert: "SELECT 10;", Labels: []string{"dev"}},
+			{ID: 2, Apply: "SELECT 2;", Revert: "SELECT 20;"},
+			{ID: 3, Apply: "SELECT 3;", Revert: "SELECT 30;"},
+		}),
+		Mode:   dbump.ModeRevertAll,
+		Labels: "prod",
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestLabelsSkippedSteps(t *testing.T) {
+	ctx := context.Background()
+	mm := &tests.MemMigrator{}
+	migs := []*dbump.Migration{
+		{ID: 1, Apply: "SELECT 1;", Revert: "SELECT 10;"},
+		{ID: 2, Apply: "SELECT 2;", Revert: "SELECT 20;"},
+		{ID: 3, Apply: "SELECT 3;", Revert: "SELECT 30;", Labels: []string{"dev"}},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeApplyAll,
+		Labels:   "prod",
+	}
+	failIfErr(t, dbump.Run(ctx, cfg))
+
+	// skipped migration is passed and considered applied.
+	report, err := dbump.Status(ctx, mm, dbump.NewSliceLoader(migs))
+	failIfErr(t, err)
+	mustEqual(t, report.Version, 3)
+	mustEqual(t, len(report.Pending()), 0)
+
+	entries, err := mm.History(ctx)
+	failIfErr(t, err)
+	mustEqual(t, entries[2].Phase, dbump.PhaseSkipped)
+
+	// every revert moves the version, also when only skipped migration is reverted.
+	cfg.Mode, cfg.Num = dbump.ModeRevertN, 1
+	for _, want := range []int{2, 1, 0} {
+		failIfErr(t, dbump.Run(ctx, cfg))
+		version, err := mm.Version(ctx)
+		failIfErr(t, err)
+		mustEqual(t, version, want)
+	}
+}
+
+func TestLabelsInvalid(t *testing.T) {
+	exprs := []string{
+		"dev and",
+		"(dev",
+		"dev)",
+		"dev prod",
+		"dev && prod",
+		"!",
+	}
+
+	for _, expr := range exprs {
+		cfg := dbump.Config{
+			Migrator: &tests.MockMigrator{},
+			Loader:   dbump.NewSliceLoader(nil),
+			Mode:     dbump.ModeApplyAll,
+			Labels:   expr,
+		}
+		failIfOk(t, dbump.Run(context.Background(), cfg))
+	}
+}
+
+func TestLoadLabels(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_seed@dev,test.sql": {Data: []byte(`-- dbump:labels local
+INSERT INTO users VALUES (1);
+--- apply above / revert below ---
+DELETE FROM users;
+`)},
+		"0002_grants.sql": {Data: []byte(`-- dbump:labels prod, reporting
+GRANT SELECT ON users TO reporting;
+--- apply above / revert below ---
+REVOKE SELECT ON users FROM reporting;
+`)},
+	}
+
+	migs, err := dbump.NewFileSysLoader(fsys, ".").Load()
+	failIfErr(t, err)
+
+	mustEqual(t, len(migs), 2)
+	mustEqual(t, migs[0].Labels, []string{"dev", "test", "local"})
+	mustEqual(t, migs[1].Labels, []string{"prod", "reporting"})
+	mustEqual(t, migs[1].ApplyDirectives, dbump.Directives{Labels: []string{"prod", "reporting"}})
+
+	fsys = fstest.MapFS{
+		"0001_seed.sql": {Data: []byte(`SELECT 1;
+--- apply above / revert below ---
+-- dbump:labels dev
+`)},
+	}
+	_, err = dbump.NewFileSysLoader(fsys, ".").Load()
+	failIfOk(t, err)
+}
@@ -0,0 +1,256 @@
+package dbump
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrLeaseLost is returned by LeaseLocker.Unlock when the lease was taken over by someone else
+// while it was held, for example after a stall longer than TTL.
+var ErrLeaseLost = errors.New("lease is lost")
+
+// Lease is a lock that expires if it's not extended by the owner.
+type Lease struct {
+	Owner      string    // Owner of the lease, unique per LeaseLocker.
+	Hostname   string    // Hostname of the owner.
+	AcquiredAt time.Time // AcquiredAt is a time when the lease was acquired.
+	ExpiresAt  time.Time // ExpiresAt is a time when the lease is stale and can be taken by others.
+}
+
+// LeaseStore persists leases for LeaseLocker.
+// Implemented by migrators for databases without native locks.
+type LeaseStore interface {
+	// InitLeases creates a storage for leases, called before each lock.
+	InitLeases(ctx context.Context) error
+	// Leases returns the latest state of every owner lease, expired included.
+	Leases(ctx context.Context) ([]Lease, error)
+	// PutLease creates or replaces the lease of Lease.Owner.
+	PutLease(ctx context.Context, lease Lease) error
+}
+
+// LeaseConfig for LeaseLocker.
+type LeaseConfig struct {
+	// Owner of the lease. Default is empty which means a random value.
+	Owner string
+	// Hostname of the owner. Default is empty which means os.Hostname.
+	Hostname string
+	// TTL of the lease, after that time lease can be taken by others.
+	// Default is 0 which means 1 minute.
+	TTL time.Duration
+	// HeartbeatInterval to extend the lease while it is held.
+	// Default is 0 which means TTL / 3.
+	HeartbeatInterval time.Duration
+}
+
+// LeaseLocker is a lock based on leases for migrators of databases without native locks.
+// Lease is extended in background until Unlock, a stale lease is taken over.
+// Lease taken over by someone else is not extended anymore, Err and Unlock return ErrLeaseLost.
+// Clocks of the hosts that run migrations should be synchronised.
+type LeaseLocker struct {
+	store LeaseStore
+	cfg   LeaseConfig
+
+	mu    sync.Mutex
+	lease Lease
+	lost  bool
+	stop  context.CancelFunc
+	done  chan struct{}
+}
+
+// NewLeaseLocker instantiates a new LeaseLocker.
+func NewLeaseLocker(store LeaseStore, cfg LeaseConfig) *LeaseLocker {
+	if cfg.Owner == "" {
+		cfg.Owner = randomOwner()
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Minute
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = cfg.TTL / 3
+	}
+
+	return &LeaseLocker{
+		store: store,
+		cfg:   cfg,
+	}
+}
+
+// Lock takes the lease, returns ErrMigrationAlreadyLocked when a valid lease is held by someone else.
+// Should be used by Migrator.LockDB.
+func (ll *LeaseLocker) Lock(ctx context.Context) error {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	if ll.stop != nil {
+		return errors.New("lease is already held")
+	}
+
+	if err := ll.store.InitLeases(ctx); err != nil {
+		return fmt.Errorf("init leases: %w", err)
+	}
+
+	now := time.Now()
+	if holder, ok, err := ll.holder(ctx, now); err != nil {
+		return err
+	} else if ok && holder.Owner != ll.cfg.Owner {
+		return ErrMigrationAlreadyLocked
+	}
+
+	lease := Lease{
+		Owner:      ll.cfg.Owner,
+		Hostname:   ll.cfg.Hostname,
+		AcquiredAt: now,
+		ExpiresAt:  now.Add(ll.cfg.TTL),
+	}
+	if err := ll.store.PutLease(ctx, lease); err != nil {
+		return fmt.Errorf("put lease: %w", err)
+	}
+
+	// someone might have taken the lease at the same time, the earliest wins.
+	holder, ok, err := ll.holder(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok || holder.Owner != ll.cfg.Owner {
+		lease.ExpiresAt = time.Now()
+		if err := ll.store.PutLease(ctx, lease); err != nil {
+			return fmt.Errorf("release lease: %w", err)
+		}
+		return ErrMigrationAlreadyLocked
+	}
+
+	Logger(ctx).DebugContext(ctx, "lease acquired",
+		slog.String("owner", lease.Owner), slog.Time("expires_at", lease.ExpiresAt))
+
+	ll.lease, ll.lost = lease, false
+	hbCtx, stop := context.WithCancel(context.WithoutCancel(ctx))
+	ll.stop, ll.done = stop, make(chan struct{})
+	go ll.heartbeat(hbCtx, ll.done)
+	return nil
+}
+
+// Unlock releases the lease. Does nothing when lease is not held.
+// Returns ErrLeaseLost when the lease was taken over by someone else.
+// Should be used by Migrator.UnlockDB.
+func (ll *LeaseLocker) Unlock(ctx context.Context) error {
+	ll.mu.Lock()
+	stop, done := ll.stop, ll.done
+	ll.stop, ll.done = nil, nil
+	ll.mu.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+	stop()
+	<-done
+
+	ll.mu.Lock()
+	lease, lost := ll.lease, ll.lost
+	ll.mu.Unlock()
+
+	lease.ExpiresAt = time.Now()
+	if err := ll.store.PutLease(ctx, lease); err != nil {
+		return fmt.Errorf("release lease: %w", err)
+	}
+	if lost {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Err returns ErrLeaseLost when the held lease was taken over by someone else, nil otherwise.
+// Migrator should check it before each change in a database.
+func (ll *LeaseLocker) Err() error {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	if ll.lost {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (ll *LeaseLocker) heartbeat(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(ll.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// after a stall longer than TTL the lease might be taken over, it must not be extended then.
+		holder, ok, err := ll.holder(ctx, time.Now())
+		if err == nil && ok && holder.Owner != ll.cfg.Owner {
+			Logger(ctx).ErrorContext(ctx, "lease is lost", slog.String("holder", holder.Owner))
+
+			ll.mu.Lock()
+			ll.lost = true
+			ll.mu.Unlock()
+			return
+		}
+
+		ll.mu.Lock()
+		lease := ll.lease
+		ll.mu.Unlock()
+
+		lease.ExpiresAt = time.Now().Add(ll.cfg.TTL)
+		if err == nil {
+			err = ll.store.PutLease(ctx, lease)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			Logger(ctx).ErrorContext(ctx, "lease heartbeat failed", slog.Any("error", err))
+			continue
+		}
+
+		ll.mu.Lock()
+		ll.lease = lease
+		ll.mu.Unlock()
+	}
+}
+
+// holder returns a valid lease with the earliest acquire time.
+func (ll *LeaseLocker) holder(ctx context.Context, now time.Time) (Lease, bool, error) {
+	leases, err := ll.store.Leases(ctx)
+	if err != nil {
+		return Lease{}, false, fmt.Errorf("get leases: %w", err)
+	}
+
+	var holder Lease
+	found := false
+	for _, l := range leases {
+		if !l.ExpiresAt.After(now) {
+			continue
+		}
+		if !found || l.AcquiredAt.Before(holder.AcquiredAt) ||
+			(l.AcquiredAt.Equal(holder.AcquiredAt) && l.Owner < holder.Owner) {
+			holder, found = l, true
+		}
+	}
+	return holder, found, nil
+}
+
+func randomOwner() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
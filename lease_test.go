@@ -0,0 +1,141 @@
+package dbump_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cristalhq/dbump"
+)
+
+func TestLeaseLocker(t *testing.T) {
+	ctx := context.Background()
+	store := &memLeaseStore{}
+
+	first := dbump.NewLeaseLocker(store, dbump.LeaseConfig{Owner: "first", TTL: time.Minute})
+	second := dbump.NewLeaseLocker(store, dbump.LeaseConfig{Owner: "second", TTL: time.Minute})
+
+	failIfErr(t, first.Lock(ctx))
+
+	if err := second.Lock(ctx); !errors.Is(err, dbump.ErrMigrationAlreadyLocked) {
+		t.Fatalf("want ErrMigrationAlreadyLocked, got %v", err)
+	}
+
+	failIfErr(t, first.Unlock(ctx))
+	failIfErr(t, second.Lock(ctx))
+	failIfErr(t, second.Unlock(ctx))
+
+	// nothing to unlock.
+	failIfErr(t, second.Unlock(ctx))
+}
+
+func TestLeaseLockerStale(t *testing.T) {
+	ctx := context.Background()
+	store := &memLeaseStore{}
+
+	// lease of a crashed migration.
+	failIfErr(t, store.PutLease(ctx, dbump.Lease{
+		Owner:      "crashed",
+		AcquiredAt: time.Now().Add(-2 * time.Minute),
+		ExpiresAt:  time.Now().Add(-time.Minute),
+	}))
+
+	ll := dbump.NewLeaseLocker(store, dbump.LeaseConfig{Owner: "new"})
+	failIfErr(t, ll.Lock(ctx))
+	failIfErr(t, ll.Unlock(ctx))
+}
+
+func TestLeaseLockerHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	store := &memLeaseStore{}
+
+	ll := dbump.NewLeaseLocker(store, dbump.LeaseConfig{
+		Owner:             "owner",
+		TTL:               50 * time.Millisecond,
+		HeartbeatInterval: 10 * time.Millisecond,
+	})
+	failIfErr(t, ll.Lock(ctx))
+
+	// without heartbeat lease is expired after TTL.
+	time.Sleep(150 * time.Millisecond)
+
+	other := dbump.NewLeaseLocker(store, dbump.LeaseConfig{Owner: "other"})
+	if err := other.Lock(ctx); !errors.Is(err, dbump.ErrMigrationAlreadyLocked) {
+		t.Fatalf("want ErrMigrationAlreadyLocked, got %v", err)
+	}
+
+	failIfErr(t, ll.Unlock(ctx))
+	failIfErr(t, other.Lock(ctx))
+	failIfErr(t, other.Unlock(ctx))
+}
+
+func TestLeaseLockerLost(t *testing.T) {
+	ctx := context.Background()
+	store := &memLeaseStore{}
+
+	// heartbeat is late, lease expires before it.
+	ll := dbump.NewLeaseLocker(store, dbump.LeaseConfig{
+		Owner:             "owner",
+		TTL:               20 * time.Millisecond,
+		HeartbeatInterval: 100 * time.Millisecond,
+	})
+	failIfErr(t, ll.Lock(ctx))
+	failIfErr(t, ll.Err())
+
+	time.Sleep(40 * time.Millisecond)
+	other := dbump.NewLeaseLocker(store, dbump.LeaseConfig{Owner: "other"})
+	failIfErr(t, other.Lock(ctx))
+
+	// heartbeat must not take the lease back with the earlier acquire time.
+	time.Sleep(150 * time.Millisecond)
+	third := dbump.NewLeaseLocker(store, dbump.LeaseConfig{Owner: "third"})
+	if err := third.Lock(ctx); !errors.Is(err, dbump.ErrMigrationAlreadyLocked) {
+		t.Fatalf("want ErrMigrationAlreadyLocked, got %v", err)
+	}
+	leases, err := store.Leases(ctx)
+	failIfErr(t, err)
+	for _, l := range leases {
+		if l.Owner == "owner" && l.ExpiresAt.After(time.Now()) {
+			t.Fatal("lost lease is extended")
+		}
+	}
+
+	if err := ll.Err(); !errors.Is(err, dbump.ErrLeaseLost) {
+		t.Fatalf("want ErrLeaseLost, got %v", err)
+	}
+	if err := ll.Unlock(ctx); !errors.Is(err, dbump.ErrLeaseLost) {
+		t.Fatalf("want ErrLeaseLost, got %v", err)
+	}
+	failIfErr(t, other.Unlock(ctx))
+}
+
+type memLeaseStore struct {
+	mu     sync.Mutex
+	leases map[string]dbump.Lease
+}
+
+func (s *memLeaseStore) InitLeases(ctx context.Context) error { return nil }
+
+func (s *memLeaseStore) Leases(ctx context.Context) ([]dbump.Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []dbump.Lease
+	for _, l := range s.leases {
+		res = append(res, l)
+	}
+	return res, nil
+}
+
+func (s *memLeaseStore) PutLease(ctx context.Context, lease dbump.Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.leases == nil {
+		s.leases = map[string]dbump.Lease{}
+	}
+	s.leases[lease.Owner] = lease
+	return nil
+}
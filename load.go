@@ -1,6 +1,9 @@
 package dbump
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
@@ -32,6 +35,10 @@ func (dl *DiskLoader) Load() ([]*Migration, error) {
 	return loadMigrationsFromFS(osFS{}, dl.path)
 }
 
+func (dl *DiskLoader) validateFiles() ([]*Migration, []string) {
+	return validateFS(osFS{}, dl.path)
+}
+
 // FileSysLoader can load migrations from fs.FS.
 type FileSysLoader struct {
 	fsys FS
@@ -51,6 +58,10 @@ func (el *FileSysLoader) Load() ([]*Migration, error) {
 	return loadMigrationsFromFS(el.fsys, el.path)
 }
 
+func (el *FileSysLoader) validateFiles() ([]*Migration, []string) {
+	return validateFS(el.fsys, el.path)
+}
+
 // SliceLoader loads given migrations.
 type SliceLoader struct {
 	migrations []*Migration
@@ -64,7 +75,13 @@ func NewSliceLoader(migrations []*Migration) *SliceLoader {
 }
 
 // Load is a method for Loader interface.
+// Checksum is computed for migrations which do not have it.
 func (sl *SliceLoader) Load() ([]*Migration, error) {
+	for _, m := range sl.migrations {
+		if m.Checksum == "" {
+			m.Checksum = Checksum(m.Apply, m.Revert)
+		}
+	}
 	return sl.migrations, nil
 }
 
@@ -76,8 +93,47 @@ func (sl *SliceLoader) AddMigration(m *Migration) {
 	sl.migrations = append(sl.migrations, m)
 }
 
+// AddFuncMigration to loader, apply and revert are Go functions.
+// Nil apply is reported by Validate and ModeValidate as an empty apply part, same as an empty Apply query.
+func (sl *SliceLoader) AddFuncMigration(id int, name string, apply, revert MigrationFunc) {
+	sl.AddMigration(&Migration{
+		ID:         id,
+		Name:       name,
+		ApplyFunc:  apply,
+		RevertFunc: revert,
+	})
+}
+
+// MultiLoader loads migrations from all the given loaders.
+// Useful to have Go migrations alongside SQL files.
+type MultiLoader struct {
+	loaders []Loader
+}
+
+// NewMultiLoader instantiates a new MultiLoader.
+func NewMultiLoader(loaders ...Loader) *MultiLoader {
+	return &MultiLoader{
+		loaders: loaders,
+	}
+}
+
+// Load is a method for Loader interface.
+func (ml *MultiLoader) Load() ([]*Migration, error) {
+	var migs []*Migration
+	for _, l := range ml.loaders {
+		ms, err := l.Load()
+		if err != nil {
+			return nil, err
+		}
+		migs = append(migs, ms...)
+	}
+	return migs, nil
+}
+
 var migrationRE = regexp.MustCompile(`^(\d+)_.+\.sql$`)
 
+var repeatableRE = regexp.MustCompile(`^R_.+\.sql$`)
+
 func loadMigrationsFromFS(fsys FS, path string) ([]*Migration, error) {
 	files, err := fsys.ReadDir(path)
 	if err != nil {
@@ -90,12 +146,24 @@ func loadMigrationsFromFS(fsys FS, path string) ([]*Migration, error) {
 			continue
 		}
 
-		matches := migrationRE.FindStringSubmatch(fi.Name())
-		if len(matches) != 2 {
+		name := fi.Name()
+		matches := migrationRE.FindStringSubmatch(name)
+		repeatable := repeatableRE.MatchString(name)
+		if len(matches) != 2 && !repeatable {
 			continue
 		}
 
-		m, err := loadMigrationFromFS(fsys, path, matches[1], fi.Name())
+		body, err := fsys.ReadFile(filepath.Join(path, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var m *Migration
+		if repeatable {
+			m, err = newRepeatableMigration(name, body)
+		} else {
+			m, err = newMigration(matches[1], name, body)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -105,26 +173,59 @@ func loadMigrationsFromFS(fsys FS, path string) ([]*Migration, error) {
 	return migs, nil
 }
 
-func loadMigrationFromFS(fsys FS, path, id, name string) (*Migration, error) {
-	n, err := strconv.ParseInt(id, 10, 32)
+func newMigration(id, name string, body []byte) (*Migration, error) {
+	n, err := strconv.ParseInt(id, 10, 64)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", name, err)
 	}
-
-	body, err := fsys.ReadFile(filepath.Join(path, name))
-	if err != nil {
-		return nil, err
+	// on 32-bit platforms timestamp IDs do not fit in int.
+	if n != int64(int(n)) {
+		return nil, fmt.Errorf("%s: id %d does not fit in %d-bit int", name, n, strconv.IntSize)
 	}
 
 	m, err := parseMigration(body)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%s: %w", name, err)
 	}
+	labels, err := nameLabels(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
 	m.ID = int(n)
 	m.Name = name
+	m.Labels = append(labels, m.Labels...)
 	return m, nil
 }
 
+func newRepeatableMigration(name string, body []byte) (*Migration, error) {
+	applySQL := strings.TrimSpace(string(body))
+	if strings.Contains(applySQL, MigrationDelimiter) {
+		return nil, fmt.Errorf("%s: repeatable migration cannot have revert part", name)
+	}
+
+	directives, err := parseDirectives(applySQL)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if directives.Irreversible {
+		return nil, fmt.Errorf("%s: irreversible directive is allowed only in the revert part", name)
+	}
+	labels, err := nameLabels(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return &Migration{
+		Name:            name,
+		Apply:           applySQL,
+		Checksum:        Checksum(applySQL, ""),
+		ApplyDirectives: directives,
+		Labels:          append(labels, directives.Labels...),
+		Repeatable:      true,
+	}, nil
+}
+
 func parseMigration(body []byte) (*Migration, error) {
 	parts := strings.Split(string(body), MigrationDelimiter)
 
@@ -134,12 +235,73 @@ func parseMigration(body []byte) (*Migration, error) {
 	applySQL := strings.TrimSpace(parts[0])
 	revertSQL := strings.TrimSpace(parts[1])
 
+	applyDirectives, err := parseDirectives(applySQL)
+	if err != nil {
+		return nil, fmt.Errorf("apply: %w", err)
+	}
+	revertDirectives, err := parseDirectives(revertSQL)
+	if err != nil {
+		return nil, fmt.Errorf("revert: %w", err)
+	}
+	if applyDirectives.Irreversible {
+		return nil, errors.New("apply: irreversible directive is allowed only in the revert part")
+	}
+	if len(revertDirectives.Labels) != 0 {
+		return nil, errors.New("revert: labels directive is allowed only in the apply part")
+	}
+
 	return &Migration{
-		Apply:  applySQL,
-		Revert: revertSQL,
+		Apply:            applySQL,
+		Revert:           revertSQL,
+		Checksum:         Checksum(applySQL, revertSQL),
+		ApplyDirectives:  applyDirectives,
+		RevertDirectives: revertDirectives,
+		Irreversible:     revertDirectives.Irreversible,
+		Labels:           applyDirectives.Labels,
 	}, nil
 }
 
+const directivePrefix = "-- dbump:"
+
+// parseDirectives from the leading `-- dbump:` lines of the query.
+func parseDirectives(query string) (Directives, error) {
+	var d Directives
+	for _, line := range strings.Split(query, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, directivePrefix) {
+			break
+		}
+
+		directive := strings.TrimPrefix(line, directivePrefix)
+		name, arg, _ := strings.Cut(directive, " ")
+
+		switch {
+		case directive == "no-transaction":
+			d.NoTransaction = true
+		case directive == "irreversible":
+			d.Irreversible = true
+		case name == "labels":
+			labels, err := parseLabels(arg)
+			if err != nil {
+				return Directives{}, fmt.Errorf("labels: %w", err)
+			}
+			d.Labels = append(d.Labels, labels...)
+		default:
+			return Directives{}, fmt.Errorf("unknown directive: %q", directive)
+		}
+	}
+	return d, nil
+}
+
+// Checksum of the migration queries. Used to detect changes in applied migrations.
+func Checksum(apply, revert string) string {
+	h := sha256.New()
+	h.Write([]byte(apply))
+	h.Write([]byte(MigrationDelimiter))
+	h.Write([]byte(revert))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 type osFS struct{}
 
 // Open implements dbump.FS interface.
@@ -3,6 +3,7 @@ package dbump_test
 import (
 	"embed"
 	"testing"
+	"testing/fstest"
 
 	"github.com/cristalhq/dbump"
 )
@@ -78,8 +79,98 @@ func TestSliceLoader(t *testing.T) {
 	}
 }
 
+func TestMultiLoader(t *testing.T) {
+	size := len(testdataMigrations)
+	loader := dbump.NewMultiLoader(
+		dbump.NewSliceLoader(testdataMigrations[:2]),
+		dbump.NewSliceLoader(testdataMigrations[2:]),
+	)
+
+	migs, err := loader.Load()
+	failIfErr(t, err)
+
+	mustEqual(t, len(migs), size)
+	for i := range migs {
+		mustEqual(t, migs[i], testdataMigrations[i])
+	}
+}
+
 func TestBadFormat(t *testing.T) {
 	loader := dbump.NewFileSysLoader(testdata, "testdata/bad")
 	_, err := loader.Load()
 	failIfOk(t, err)
 }
+
+func TestDiskLoaderSparse(t *testing.T) {
+	loader := dbump.NewDiskLoader("./testdata/sparse")
+	migs, err := loader.Load()
+	failIfErr(t, err)
+
+	mustEqual(t, len(migs), 2)
+	mustEqual(t, migs[0].ID, 20221016120000)
+	mustEqual(t, migs[1].ID, 20221017090000)
+}
+
+func TestLoaderIDOverflow(t *testing.T) {
+	fsys := fstest.MapFS{
+		"99999999999999999999_huge.sql": {Data: []byte("SELECT 1;\n--- apply above / revert below ---\nSELECT 10;\n")},
+	}
+
+	_, err := dbump.NewFileSysLoader(fsys, ".").Load()
+	failIfOk(t, err)
+}
+
+func TestDirectives(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_index.sql": {Data: []byte(`-- dbump:no-transaction
+CREATE INDEX CONCURRENTLY idx ON t (id);
+--- apply above / revert below ---
+DROP INDEX idx;
+`)},
+	}
+
+	migs, err := dbump.NewFileSysLoader(fsys, ".").Load()
+	failIfErr(t, err)
+
+	mustEqual(t, len(migs), 1)
+	mustEqual(t, migs[0].ApplyDirectives, dbump.Directives{NoTransaction: true})
+	mustEqual(t, migs[0].RevertDirectives, dbump.Directives{})
+}
+
+func TestIrreversibleDirective(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_drop.sql": {Data: []byte(`ALTER TABLE t DROP COLUMN c;
+--- apply above / revert below ---
+-- dbump:irreversible
+`)},
+	}
+
+	migs, err := dbump.NewFileSysLoader(fsys, ".").Load()
+	failIfErr(t, err)
+
+	mustEqual(t, len(migs), 1)
+	mustEqual(t, migs[0].Irreversible, true)
+	mustEqual(t, migs[0].RevertDirectives, dbump.Directives{Irreversible: true})
+
+	fsys = fstest.MapFS{
+		"0001_drop.sql": {Data: []byte(`-- dbump:irreversible
+ALTER TABLE t DROP COLUMN c;
+--- apply above / revert below ---
+`)},
+	}
+	_, err = dbump.NewFileSysLoader(fsys, ".").Load()
+	failIfOk(t, err)
+}
+
+func TestUnknownDirective(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_index.sql": {Data: []byte(`SELECT 1;
+--- apply above / revert below ---
+-- dbump:no-transation
+SELECT 10;
+`)},
+	}
+
+	_, err := dbump.NewFileSysLoader(fsys, ".").Load()
+	mustEqual(t, err.Error(), `0001_index.sql: revert: unknown directive: "no-transation"`)
+}
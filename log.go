@@ -0,0 +1,42 @@
+package dbump
+
+import (
+	"context"
+	"log/slog"
+)
+
+type loggerKey struct{}
+
+// Logger returns the logger from Config.Logger that is passed by Run and Plan in the context.
+// Migrators should use it to log through the same logger as dbump.
+// When there is no logger in the context returned logger discards all the records.
+func Logger(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return discardLogger
+}
+
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+var discardLogger = slog.New(discardHandler{})
+
+// discardHandler drops all the records, same as slog.DiscardHandler from Go 1.24.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
+
+func stepAttrs(step Step) []any {
+	return []any{
+		slog.Int("id", step.MigrationID),
+		slog.String("name", step.Name),
+		slog.String("direction", string(step.Direction)),
+		slog.String("phase", string(step.Phase)),
+		slog.Int("version", step.Version),
+	}
+}
@@ -0,0 +1,238 @@
+package dbump
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrReadOnly is returned by a Migrator wrapped with ReadOnly on any write.
+var ErrReadOnly = errors.New("migrator is read-only")
+
+// Middleware wraps Migrator to add a cross-cutting behaviour like timing or tracing.
+// See Config.Middlewares.
+//
+// Wrapper should have `Unwrap() Migrator` method to give access to the optional interfaces
+// of the wrapped Migrator, like HistoryMigrator. Wrappers made with Intercept have it.
+type Middleware func(Migrator) Migrator
+
+// Call of a Migrator method, passed to Interceptor.
+type Call struct {
+	// Method of Migrator: "LockDB", "UnlockDB", "Init", "Drop", "Version" or "DoStep".
+	Method string
+	// Step for the DoStep method.
+	Step Step
+}
+
+// Interceptor is invoked for each Migrator method call, next invokes the wrapped Migrator.
+// Call can be changed before passing to next, for example to rewrite the step query.
+type Interceptor func(ctx context.Context, call Call, next func(ctx context.Context, call Call) error) error
+
+// Intercept returns Middleware that invokes fn for every Migrator method call.
+// Methods of the optional interfaces (like HistoryMigrator) are not intercepted,
+// except steps done via TxMigrator, they are passed to fn as DoStep calls.
+func Intercept(fn Interceptor) Middleware {
+	return func(m Migrator) Migrator {
+		i := &interceptor{m: m, fn: fn}
+		if tm, ok := asMigrator[TxMigrator](m); ok {
+			return &interceptorTx{interceptor: i, tm: tm}
+		}
+		return i
+	}
+}
+
+// Timing returns Middleware that reports duration and error of each Migrator method call.
+func Timing(observe func(ctx context.Context, method string, took time.Duration, err error)) Middleware {
+	return Intercept(func(ctx context.Context, call Call, next func(ctx context.Context, call Call) error) error {
+		start := time.Now()
+		err := next(ctx, call)
+		observe(ctx, call.Method, time.Since(start), err)
+		return err
+	})
+}
+
+// ReadOnly returns Middleware that forbids changes in a database.
+// DoStep, Drop and HistoryMigrator.SetChecksum return ErrReadOnly, Init does nothing.
+// DirtyMigrator is always implemented, nothing is dirty when the wrapped Migrator doesn't have it.
+// RepeatableMigrator is always implemented too, it returns an error when the wrapped Migrator doesn't have it.
+// Other optional interfaces of the wrapped Migrator are hidden.
+// Useful together with Plan or to be sure that a run has nothing to do.
+func ReadOnly() Middleware {
+	return func(m Migrator) Migrator {
+		ro := readOnly{m: m}
+		if hm, ok := asMigrator[HistoryMigrator](m); ok {
+			return &readOnlyHistory{readOnly: ro, hm: hm}
+		}
+		return &ro
+	}
+}
+
+// RecordedCall is a Migrator method call saved by Recorder.
+type RecordedCall struct {
+	Call
+	Err error
+}
+
+// Recorder saves Migrator method calls, see Recorder.Middleware.
+// Safe for concurrent use.
+type Recorder struct {
+	mu    sync.Mutex
+	calls []RecordedCall
+}
+
+// Middleware returns Middleware that saves calls to the Recorder.
+func (r *Recorder) Middleware() Middleware {
+	return Intercept(func(ctx context.Context, call Call, next func(ctx context.Context, call Call) error) error {
+		err := next(ctx, call)
+
+		r.mu.Lock()
+		r.calls = append(r.calls, RecordedCall{Call: call, Err: err})
+		r.mu.Unlock()
+		return err
+	})
+}
+
+// Calls returns all the recorded calls in the order they were made.
+func (r *Recorder) Calls() []RecordedCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedCall(nil), r.calls...)
+}
+
+// applyMiddlewares to the migrator, first middleware is the outermost.
+func applyMiddlewares(m Migrator, mws []Middleware) Migrator {
+	for i := len(mws) - 1; i >= 0; i-- {
+		m = mws[i](m)
+	}
+	return m
+}
+
+// asMigrator finds an optional interface T in the chain of wrapped migrators.
+func asMigrator[T any](m Migrator) (T, bool) {
+	for {
+		if t, ok := m.(T); ok {
+			return t, true
+		}
+		u, ok := m.(interface{ Unwrap() Migrator })
+		if !ok {
+			var zero T
+			return zero, false
+		}
+		m = u.Unwrap()
+	}
+}
+
+type interceptor struct {
+	m  Migrator
+	fn Interceptor
+}
+
+func (i *interceptor) Unwrap() Migrator { return i.m }
+
+func (i *interceptor) LockDB(ctx context.Context) error {
+	return i.call(ctx, Call{Method: "LockDB"})
+}
+
+func (i *interceptor) UnlockDB(ctx context.Context) error {
+	return i.call(ctx, Call{Method: "UnlockDB"})
+}
+
+func (i *interceptor) Init(ctx context.Context) error {
+	return i.call(ctx, Call{Method: "Init"})
+}
+
+func (i *interceptor) Drop(ctx context.Context) error {
+	return i.call(ctx, Call{Method: "Drop"})
+}
+
+func (i *interceptor) Version(ctx context.Context) (version int, err error) {
+	err = i.fn(ctx, Call{Method: "Version"}, func(ctx context.Context, call Call) error {
+		var err error
+		version, err = i.m.Version(ctx)
+		return err
+	})
+	return version, err
+}
+
+func (i *interceptor) DoStep(ctx context.Context, step Step) error {
+	return i.call(ctx, Call{Method: "DoStep", Step: step})
+}
+
+func (i *interceptor) call(ctx context.Context, call Call) error {
+	return i.fn(ctx, call, i.next)
+}
+
+func (i *interceptor) next(ctx context.Context, call Call) error {
+	switch call.Method {
+	case "LockDB":
+		return i.m.LockDB(ctx)
+	case "UnlockDB":
+		return i.m.UnlockDB(ctx)
+	case "Init":
+		return i.m.Init(ctx)
+	case "Drop":
+		return i.m.Drop(ctx)
+	case "DoStep":
+		return i.m.DoStep(ctx, call.Step)
+	default:
+		panic("unreachable")
+	}
+}
+
+type interceptorTx struct {
+	*interceptor
+	tm TxMigrator
+}
+
+func (i *interceptorTx) InTx(ctx context.Context, fn func(ctx context.Context, doStep func(ctx context.Context, step Step) error) error) error {
+	return i.tm.InTx(ctx, func(ctx context.Context, doStep func(ctx context.Context, step Step) error) error {
+		return fn(ctx, func(ctx context.Context, step Step) error {
+			return i.fn(ctx, Call{Method: "DoStep", Step: step}, func(ctx context.Context, call Call) error {
+				return doStep(ctx, call.Step)
+			})
+		})
+	})
+}
+
+type readOnly struct {
+	m Migrator
+}
+
+func (ro *readOnly) LockDB(ctx context.Context) error   { return ro.m.LockDB(ctx) }
+func (ro *readOnly) UnlockDB(ctx context.Context) error { return ro.m.UnlockDB(ctx) }
+func (ro *readOnly) Init(ctx context.Context) error     { return nil }
+func (ro *readOnly) Drop(ctx context.Context) error     { return ErrReadOnly }
+
+func (ro *readOnly) Version(ctx context.Context) (version int, err error) {
+	return ro.m.Version(ctx)
+}
+
+func (ro *readOnly) DoStep(ctx context.Context, step Step) error { return ErrReadOnly }
+
+func (ro *readOnly) Dirty(ctx context.Context) (*LogEntry, error) {
+	if dm, ok := asMigrator[DirtyMigrator](ro.m); ok {
+		return dm.Dirty(ctx)
+	}
+	return nil, nil
+}
+
+func (ro *readOnly) RepeatableChecksums(ctx context.Context) (map[string]string, error) {
+	if rm, ok := asMigrator[RepeatableMigrator](ro.m); ok {
+		return rm.RepeatableChecksums(ctx)
+	}
+	return nil, errors.New("migrator does not support repeatable migrations")
+}
+
+type readOnlyHistory struct {
+	readOnly
+	hm HistoryMigrator
+}
+
+func (ro *readOnlyHistory) History(ctx context.Context) ([]LogEntry, error) {
+	return ro.hm.History(ctx)
+}
+
+func (ro *readOnlyHistory) SetChecksum(ctx context.Context, version int, checksum string) error {
+	return ErrReadOnly
+}
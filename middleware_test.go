@@ -0,0 +1,229 @@
+package dbump_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cristalhq/dbump"
+	"github.com/cristalhq/dbump/tests"
+)
+
+func TestMiddlewaresOrder(t *testing.T) {
+	wantLog := []string{
+		"lockdb", "init", "getversion",
+		"dostep", "{v:5 q:'/* outer */ /* inner */ SELECT 5;' notx:false}",
+		"unlockdb",
+	}
+
+	rewrite := func(comment string) dbump.Middleware {
+		return dbump.Intercept(func(ctx context.Context, call dbump.Call, next func(ctx context.Context, call dbump.Call) error) error {
+			if call.Method == "DoStep" {
+				call.Step.Query = comment + " " + call.Step.Query
+			}
+			return next(ctx, call)
+		})
+	}
+
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 4, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeApplyAll,
+		Middlewares: []dbump.Middleware{
+			rewrite("/* inner */"),
+			rewrite("/* outer */"),
+		},
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+}
+
+func TestRecorderAndTiming(t *testing.T) {
+	errStep := errors.New("no access")
+
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 4, nil
+		},
+		DoStepFn: func(ctx context.Context, step dbump.Step) error {
+			return errStep
+		},
+	}
+
+	var methods []string
+	rec := &dbump.Recorder{}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(testdataMigrations),
+		Mode:     dbump.ModeApplyAll,
+		Middlewares: []dbump.Middleware{
+			rec.Middleware(),
+			dbump.Timing(func(ctx context.Context, method string, took time.Duration, err error) {
+				methods = append(methods, method)
+			}),
+		},
+	}
+
+	failIfOk(t, dbump.Run(context.Background(), cfg))
+
+	wantMethods := []string{"LockDB", "Init", "Version", "DoStep", "UnlockDB"}
+	mustEqual(t, methods, wantMethods)
+
+	calls := rec.Calls()
+	if len(calls) != len(wantMethods) {
+		t.Fatalf("want %d calls, got %d", len(wantMethods), len(calls))
+	}
+	for i, call := range calls {
+		mustEqual(t, call.Method, wantMethods[i])
+	}
+	mustEqual(t, calls[3].Step.Version, 5)
+	mustEqual(t, calls[3].Err, errStep)
+}
+
+func TestMiddlewareKeepsHistory(t *testing.T) {
+	mm := &tests.MockHistoryMigrator{
+		MockMigrator: &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 1, nil
+			},
+		},
+		HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+			return []dbump.LogEntry{{Version: 1, Checksum: "edited"}}, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator:    mm,
+		Loader:      dbump.NewSliceLoader(testdataMigrations),
+		Mode:        dbump.ModeApplyAll,
+		Middlewares: []dbump.Middleware{(&dbump.Recorder{}).Middleware()},
+	}
+
+	var errChecksum *dbump.ChecksumError
+	if err := dbump.Run(context.Background(), cfg); !errors.As(err, &errChecksum) {
+		t.Fatalf("want ChecksumError, got %v", err)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	testCases := []struct {
+		testName string
+		mode     dbump.MigratorMode
+		wantLog  []string
+	}{
+		{
+			testName: "apply",
+			mode:     dbump.ModeApplyAll,
+			wantLog:  []string{"lockdb", "getversion", "history", "unlockdb"},
+		},
+		{
+			testName: "repair checksums",
+			mode:     dbump.ModeRepairChecksums,
+			wantLog:  []string{"lockdb", "getversion", "history", "unlockdb"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			mm := &tests.MockHistoryMigrator{
+				MockMigrator: &tests.MockMigrator{
+					VersionFn: func(ctx context.Context) (version int, err error) {
+						return 2, nil
+					},
+				},
+				HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+					return []dbump.LogEntry{{Version: 1}, {Version: 2}}, nil
+				},
+			}
+			cfg := dbump.Config{
+				Migrator:    mm,
+				Loader:      dbump.NewSliceLoader(testdataMigrations),
+				Mode:        tc.mode,
+				Middlewares: []dbump.Middleware{dbump.ReadOnly()},
+			}
+
+			err := dbump.Run(context.Background(), cfg)
+			if !errors.Is(err, dbump.ErrReadOnly) {
+				t.Fatalf("want ErrReadOnly, got %v", err)
+			}
+			mustEqual(t, mm.Log(), tc.wantLog)
+		})
+	}
+}
+
+func TestReadOnlyDirty(t *testing.T) {
+	mm := &tests.MockDirtyMigrator{
+		MockHistoryMigrator: &tests.MockHistoryMigrator{
+			MockMigrator: &tests.MockMigrator{},
+		},
+		DirtyFn: func(ctx context.Context) (*dbump.LogEntry, error) {
+			return &dbump.LogEntry{Version: 1, MigrationID: 1}, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator:    mm,
+		Loader:      dbump.NewSliceLoader(testdataMigrations),
+		Mode:        dbump.ModeApplyAll,
+		Middlewares: []dbump.Middleware{dbump.ReadOnly()},
+	}
+
+	var errDirty *dbump.DirtyError
+	if err := dbump.Run(context.Background(), cfg); !errors.As(err, &errDirty) {
+		t.Fatalf("want DirtyError, got %v", err)
+	}
+	mustEqual(t, mm.Log(), []string{"lockdb", "dirty", "unlockdb"})
+}
+
+func TestReadOnlyRepeatable(t *testing.T) {
+	mm := &tests.MockRepeatableMigrator{
+		MockMigrator: &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 1, nil
+			},
+		},
+		RepeatableChecksumsFn: func(ctx context.Context) (map[string]string, error) {
+			return map[string]string{"R_a.sql": dbump.Checksum("CREATE VIEW a;", "")}, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader: dbump.NewSliceLoader([]*dbump.Migration{
+			{ID: 1, Apply: "SELECT 1;", Revert: "SELECT 10;"},
+			{Name: "R_a.sql", Apply: "CREATE VIEW a;", Repeatable: true},
+			{Name: "R_b.sql", Apply: "CREATE VIEW b;", Repeatable: true},
+		}),
+		Mode:        dbump.ModeApplyAll,
+		Middlewares: []dbump.Middleware{dbump.ReadOnly()},
+	}
+
+	plan, err := dbump.Plan(context.Background(), cfg)
+	failIfErr(t, err)
+	mustEqual(t, len(plan.Steps), 1)
+	mustEqual(t, plan.Steps[0].Name, "R_b.sql")
+	mustEqual(t, mm.Log(), []string{"getversion", "repeatablechecksums"})
+}
+
+func TestReadOnlyPlan(t *testing.T) {
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 3, nil
+		},
+	}
+	cfg := dbump.Config{
+		Migrator:    mm,
+		Loader:      dbump.NewSliceLoader(testdataMigrations),
+		Mode:        dbump.ModeApplyAll,
+		Middlewares: []dbump.Middleware{dbump.ReadOnly()},
+	}
+
+	plan, err := dbump.Plan(context.Background(), cfg)
+	failIfErr(t, err)
+	mustEqual(t, len(plan.Steps), 2)
+	mustEqual(t, mm.Log(), []string{"getversion"})
+}
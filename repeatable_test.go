@@ -0,0 +1,137 @@
+package dbump_test
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/cristalhq/dbump"
+	"github.com/cristalhq/dbump/tests"
+)
+
+func TestRepeatable(t *testing.T) {
+	testCases := []struct {
+		testName string
+		mode     dbump.MigratorMode
+		wantLog  []string
+	}{
+		{
+			testName: "apply",
+			mode:     dbump.ModeApplyAll,
+			wantLog: []string{
+				"lockdb", "init", "getversion", "repeatablechecksums",
+				"dostep", "{v:2 q:'SELECT 2;' notx:false}",
+				"dostep", "{v:2 q:'CREATE VIEW a;' notx:false}",
+				"dostep", "{v:2 q:'CREATE VIEW c;' notx:false}",
+				"unlockdb",
+			},
+		},
+		{
+			testName: "revert",
+			mode:     dbump.ModeRevertN,
+			wantLog: []string{
+				"lockdb", "init", "getversion",
+				"dostep", "{v:0 q:'SELECT 10;' notx:false}",
+				"unlockdb",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			mm := &tests.MockRepeatableMigrator{
+				MockMigrator: &tests.MockMigrator{
+					VersionFn: func(ctx context.Context) (version int, err error) {
+						return 1, nil
+					},
+				},
+				RepeatableChecksumsFn: func(ctx context.Context) (map[string]string, error) {
+					return map[string]string{
+						"R_a.sql": "old",
+						"R_b.sql": dbump.Checksum("CREATE VIEW b;", ""),
+					}, nil
+				},
+			}
+			cfg := dbump.Config{
+				Migrator: mm,
+				Loader: dbump.NewSliceLoader([]*dbump.Migration{
+					{Name: "R_c.sql", Apply: "CREATE VIEW c;", Repeatable: true},
+					{ID: 1, Apply: "SELECT 1;", Revert: "SELECT 10;"},
+					{Name: "R_b.sql", Apply: "CREATE VIEW b;", Repeatable: true},
+					{ID: 2, Apply: "SELECT 2;", Revert: "SELECT 20;"},
+					{Name: "R_a.sql", Apply: "CREATE VIEW a;", Repeatable: true},
+				}),
+				Mode: tc.mode,
+				Num:  1,
+			}
+
+			failIfErr(t, dbump.Run(context.Background(), cfg))
+			mustEqual(t, mm.Log(), tc.wantLog)
+		})
+	}
+}
+
+func TestRepeatableErrors(t *testing.T) {
+	testCases := []struct {
+		testName string
+		migrator dbump.Migrator
+		ms       []*dbump.Migration
+	}{
+		{
+			testName: "not supported",
+			migrator: &tests.MockMigrator{},
+			ms:       []*dbump.Migration{{Name: "R_a.sql", Apply: "SELECT 1;", Repeatable: true}},
+		},
+		{
+			testName: "no name",
+			migrator: &tests.MockRepeatableMigrator{MockMigrator: &tests.MockMigrator{}},
+			ms:       []*dbump.Migration{{Apply: "SELECT 1;", Repeatable: true}},
+		},
+		{
+			testName: "duplicate",
+			migrator: &tests.MockRepeatableMigrator{MockMigrator: &tests.MockMigrator{}},
+			ms: []*dbump.Migration{
+				{Name: "R_a.sql", Apply: "SELECT 1;", Repeatable: true},
+				{Name: "R_a.sql", Apply: "SELECT 2;", Repeatable: true},
+			},
+		},
+		{
+			testName: "revert",
+			migrator: &tests.MockRepeatableMigrator{MockMigrator: &tests.MockMigrator{}},
+			ms:       []*dbump.Migration{{Name: "R_a.sql", Apply: "SELECT 1;", Revert: "SELECT 2;", Repeatable: true}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			cfg := dbump.Config{
+				Migrator: tc.migrator,
+				Loader:   dbump.NewSliceLoader(tc.ms),
+				Mode:     dbump.ModeApplyAll,
+			}
+			failIfOk(t, dbump.Run(context.Background(), cfg))
+		})
+	}
+}
+
+func TestLoadRepeatable(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_init.sql": {Data: []byte("CREATE TABLE t (id INT);\n--- apply above / revert below ---\nDROP TABLE t;")},
+		"R_views.sql":   {Data: []byte("CREATE OR REPLACE VIEW v AS SELECT id FROM t;\n")},
+	}
+
+	migs, err := dbump.NewFileSysLoader(fsys, ".").Load()
+	failIfErr(t, err)
+
+	mustEqual(t, len(migs), 2)
+	mustEqual(t, migs[1].Repeatable, true)
+	mustEqual(t, migs[1].Name, "R_views.sql")
+	mustEqual(t, migs[1].Apply, "CREATE OR REPLACE VIEW v AS SELECT id FROM t;")
+	mustEqual(t, migs[1].Checksum, dbump.Checksum(migs[1].Apply, ""))
+
+	fsys = fstest.MapFS{
+		"R_views.sql": {Data: []byte("CREATE VIEW v AS SELECT 1;\n--- apply above / revert below ---\nDROP VIEW v;")},
+	}
+	_, err = dbump.NewFileSysLoader(fsys, ".").Load()
+	failIfOk(t, err)
+}
@@ -0,0 +1,224 @@
+package dbump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// StatementError is returned when one of the statements of a step fails.
+// See ExecStatements.
+type StatementError struct {
+	Num       int    // Num of the statement in the query, starts from 1.
+	Statement string // Statement that failed.
+	Err       error  // Err returned for the statement.
+}
+
+func (e *StatementError) Error() string {
+	return fmt.Sprintf("statement %d: %s", e.Num, e.Err)
+}
+
+func (e *StatementError) Unwrap() error { return e.Err }
+
+// ExecStatements splits the query with SplitStatements and runs exec for each statement.
+// Useful for databases that cannot run few statements in one query.
+// Error from exec is wrapped into StatementError.
+func ExecStatements(ctx context.Context, query string, exec func(ctx context.Context, stmt string) error) error {
+	stmts, err := SplitStatements(query)
+	if err != nil {
+		return err
+	}
+
+	for i, stmt := range stmts {
+		if err := exec(ctx, stmt); err != nil {
+			return &StatementError{
+				Num:       i + 1,
+				Statement: stmt,
+				Err:       err,
+			}
+		}
+	}
+	return nil
+}
+
+// SplitStatements splits the query into statements separated by semicolon.
+// Semicolons inside quotes, comments, dollar-quoted strings (Postgres)
+// and BEGIN ... END bodies (triggers, procedures) are ignored.
+// Comments are "--" and "#" (MySQL) to the end of line and "/* */",
+// except "#>" and "#-" which are Postgres JSON operators.
+// Statements are trimmed and empty statements are skipped.
+func SplitStatements(query string) ([]string, error) {
+	var stmts []string
+	depth := 0
+	start := 0
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end, ok := skipQuoted(query[i:])
+			if !ok {
+				return nil, fmt.Errorf("unterminated quote at %d", i)
+			}
+			i += end
+
+		case strings.HasPrefix(query[i:], "--") || isHashComment(query[i:]):
+			end := strings.IndexByte(query[i:], '\n')
+			if end == -1 {
+				end = len(query) - i
+			}
+			i += end
+
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated comment at %d", i)
+			}
+			i += end + 4
+
+		case c == '$':
+			tag, ok := dollarTag(query[i:])
+			if !ok {
+				i++
+				continue
+			}
+			end := strings.Index(query[i+len(tag):], tag)
+			if end == -1 {
+				return nil, fmt.Errorf("unterminated dollar-quoted string at %d", i)
+			}
+			i += len(tag) + end + len(tag)
+
+		case c == ';':
+			if depth == 0 {
+				stmts = appendStatement(stmts, query[start:i])
+				start = i + 1
+			}
+			i++
+
+		case isWordStart(query, i):
+			word := readWord(query[i:])
+			switch strings.ToUpper(word) {
+			case "BEGIN":
+				if isBlockBegin(query[i+len(word):]) {
+					depth++
+				}
+			case "CASE":
+				depth++
+			case "END":
+				rest := query[i+len(word):]
+				next := readWord(strings.TrimLeftFunc(rest, unicode.IsSpace))
+
+				switch strings.ToUpper(next) {
+				case "IF", "LOOP", "WHILE", "REPEAT":
+					// END of a statement that doesn't increase the depth.
+				case "CASE":
+					// END CASE closes CASE statement, skip CASE to not count it again.
+					if depth > 0 {
+						depth--
+					}
+					i += len(rest) - len(strings.TrimLeftFunc(rest, unicode.IsSpace)) + len(next)
+				default:
+					if depth > 0 {
+						depth--
+					}
+				}
+			}
+			i += len(word)
+
+		default:
+			i++
+		}
+	}
+
+	if depth != 0 {
+		return nil, errors.New("unterminated BEGIN ... END block")
+	}
+	return appendStatement(stmts, query[start:]), nil
+}
+
+func appendStatement(stmts []string, stmt string) []string {
+	stmt = strings.TrimSpace(stmt)
+	if stmt == "" {
+		return stmts
+	}
+	return append(stmts, stmt)
+}
+
+// skipQuoted returns length of the quoted string at the beginning of s.
+// Quote is escaped by doubling it or with a backslash (MySQL, ClickHouse).
+func skipQuoted(s string) (int, bool) {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if q != '`' {
+				i++
+			}
+		case q:
+			if i+1 < len(s) && s[i+1] == q {
+				i++
+				continue
+			}
+			return i + 1, true
+		}
+	}
+	return 0, false
+}
+
+// dollarTag returns tag like $$ or $body$ from the beginning of s.
+func dollarTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		switch c := rune(s[i]); {
+		case c == '$':
+			return s[:i+1], true
+		case c == '_' || unicode.IsLetter(c) || (i > 1 && unicode.IsDigit(c)):
+			// pass
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// isHashComment reports whether s starts with MySQL "#" comment.
+func isHashComment(s string) bool {
+	if s == "" || s[0] != '#' {
+		return false
+	}
+	return len(s) == 1 || (s[1] != '>' && s[1] != '-')
+}
+
+func isWordStart(s string, i int) bool {
+	if !isWordChar(s[i]) {
+		return false
+	}
+	return i == 0 || !isWordChar(s[i-1])
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+func readWord(s string) string {
+	i := 0
+	for i < len(s) && isWordChar(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+// isBlockBegin reports whether BEGIN starts a block and not a transaction.
+func isBlockBegin(rest string) bool {
+	rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	if rest == "" || rest[0] == ';' {
+		return false
+	}
+	switch strings.ToUpper(readWord(rest)) {
+	case "TRANSACTION", "WORK", "ISOLATION", "READ", "DEFERRABLE", "NOT":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package dbump_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cristalhq/dbump"
+)
+
+func TestSplitStatements(t *testing.T) {
+	testCases := []struct {
+		testName string
+		query    string
+		want     []string
+	}{
+		{
+			testName: "empty",
+			query:    " \n ",
+			want:     nil,
+		},
+		{
+			testName: "single without semicolon",
+			query:    "SELECT 1",
+			want:     []string{"SELECT 1"},
+		},
+		{
+			testName: "few statements",
+			query:    "CREATE TABLE a (id INT);\n\nCREATE TABLE b (id INT);\n;",
+			want:     []string{"CREATE TABLE a (id INT)", "CREATE TABLE b (id INT)"},
+		},
+		{
+			testName: "quotes",
+			query:    `INSERT INTO t VALUES ('a;b', 'it''s;', 'c\';d', "e;f", ` + "`g;h`" + `); SELECT 2`,
+			want: []string{
+				`INSERT INTO t VALUES ('a;b', 'it''s;', 'c\';d', "e;f", ` + "`g;h`" + `)`,
+				`SELECT 2`,
+			},
+		},
+		{
+			testName: "comments",
+			query:    "SELECT 1; -- comment; here\nSELECT 2 /* and; here */;",
+			want:     []string{"SELECT 1", "-- comment; here\nSELECT 2 /* and; here */"},
+		},
+		{
+			testName: "hash comments",
+			query:    "# c;\nSELECT 1; SELECT 2 # and; here\n;",
+			want:     []string{"# c;\nSELECT 1", "SELECT 2 # and; here"},
+		},
+		{
+			testName: "json operators",
+			query:    "SELECT data #> '{a}' FROM t; SELECT data #- '{a}' FROM t;",
+			want:     []string{"SELECT data #> '{a}' FROM t", "SELECT data #- '{a}' FROM t"},
+		},
+		{
+			testName: "dollar quoted",
+			query: `CREATE FUNCTION f() RETURNS INT AS $body$
+BEGIN
+	RETURN 1;
+END;
+$body$ LANGUAGE plpgsql;
+SELECT $1, $$;$$;`,
+			want: []string{
+				"CREATE FUNCTION f() RETURNS INT AS $body$\nBEGIN\n\tRETURN 1;\nEND;\n$body$ LANGUAGE plpgsql",
+				"SELECT $1, $$;$$",
+			},
+		},
+		{
+			testName: "begin end",
+			query: `CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW
+BEGIN
+	IF NEW.a < 0 THEN
+		SET NEW.a = 0;
+	END IF;
+	CASE NEW.b WHEN 1 THEN SET NEW.c = 1; ELSE SET NEW.c = 2; END CASE;
+	SET NEW.d = CASE WHEN NEW.a > 1 THEN 1 ELSE 0 END;
+END;
+SELECT 1;`,
+			want: []string{
+				"CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW\nBEGIN\n\tIF NEW.a < 0 THEN\n\t\tSET NEW.a = 0;\n\tEND IF;\n\tCASE NEW.b WHEN 1 THEN SET NEW.c = 1; ELSE SET NEW.c = 2; END CASE;\n\tSET NEW.d = CASE WHEN NEW.a > 1 THEN 1 ELSE 0 END;\nEND",
+				"SELECT 1",
+			},
+		},
+		{
+			testName: "transaction",
+			query:    "BEGIN; SELECT 1; END; BEGIN TRANSACTION; SELECT 2; COMMIT;",
+			want:     []string{"BEGIN", "SELECT 1", "END", "BEGIN TRANSACTION", "SELECT 2", "COMMIT"},
+		},
+	}
+
+	for _, tc := range testCases {
+		stmts, err := dbump.SplitStatements(tc.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.testName, err)
+		}
+		mustEqual(t, stmts, tc.want)
+	}
+}
+
+func TestSplitStatementsUnterminated(t *testing.T) {
+	queries := []string{
+		"SELECT 'abc",
+		`SELECT "abc`,
+		"SELECT 1 /* comment",
+		"SELECT $tag$ body",
+		"CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN SET NEW.a = 1;",
+	}
+
+	for _, query := range queries {
+		_, err := dbump.SplitStatements(query)
+		failIfOk(t, err)
+	}
+}
+
+func TestExecStatements(t *testing.T) {
+	var got []string
+	errExec := errors.New("syntax error")
+
+	err := dbump.ExecStatements(context.Background(), "SELECT 1; SELEC 2; SELECT 3;",
+		func(ctx context.Context, stmt string) error {
+			got = append(got, stmt)
+			if stmt == "SELEC 2" {
+				return errExec
+			}
+			return nil
+		})
+
+	var errStmt *dbump.StatementError
+	if !errors.As(err, &errStmt) {
+		t.Fatalf("want StatementError, got %v", err)
+	}
+	mustEqual(t, errStmt.Num, 2)
+	mustEqual(t, errStmt.Statement, "SELEC 2")
+	mustEqual(t, errors.Is(err, errExec), true)
+	mustEqual(t, err.Error(), "statement 2: syntax error")
+	mustEqual(t, got, []string{"SELECT 1", "SELEC 2"})
+}
@@ -0,0 +1,112 @@
+package dbump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// StatusReport describes the state of a database regarding the loaded migrations.
+type StatusReport struct {
+	// Version of the database.
+	Version int
+	// Migrations with their state, ordered by ID.
+	Migrations []MigrationStatus
+	// Ahead is true when database version is greater than the loaded migrations.
+	Ahead bool
+	// Dirty is a step that was started but not finished, see DirtyMigrator.
+	// Nil when database is clean or Migrator does not implement DirtyMigrator.
+	Dirty *LogEntry
+}
+
+// MigrationStatus is a state of a single migration.
+type MigrationStatus struct {
+	Migration *Migration
+	// Applied is true when migration is applied to the database.
+	// Migrations below the current version might be not applied, see Config.AllowOutOfOrder.
+	Applied bool
+	// AppliedAt is a time when migration was applied.
+	// Zero when migration is pending or when Migrator does not implement HistoryMigrator.
+	AppliedAt time.Time
+}
+
+// Pending returns migrations that are not applied yet.
+func (r *StatusReport) Pending() []*Migration {
+	var res []*Migration
+	for _, ms := range r.Migrations {
+		if !ms.Applied {
+			res = append(res, ms.Migration)
+		}
+	}
+	return res
+}
+
+// Status of the database with the migrations provided by the Loader.
+// Database lock is not taken, however Migrator.Init is called to be able to get the current version.
+func Status(ctx context.Context, migrator Migrator, loader Loader) (*StatusReport, error) {
+	switch {
+	case migrator == nil:
+		return nil, errors.New("migrator cannot be nil")
+	case loader == nil:
+		return nil, errors.New("loader cannot be nil")
+	}
+
+	// status is fine with gaps between IDs.
+	m := &mig{
+		Config:   Config{SparseIDs: true},
+		Migrator: migrator,
+		Loader:   loader,
+	}
+	return m.status(ctx)
+}
+
+func (m *mig) status(ctx context.Context) (*StatusReport, error) {
+	ms, err := m.load()
+	if err != nil {
+		return nil, fmt.Errorf("load: %w", err)
+	}
+
+	if err := m.Init(ctx); err != nil {
+		return nil, fmt.Errorf("init: %w", err)
+	}
+
+	curr, err := m.Migrator.Version(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get version: %w", err)
+	}
+
+	var applied map[int]LogEntry
+	if hm, ok := asMigrator[HistoryMigrator](m.Migrator); ok {
+		entries, err := hm.History(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get history: %w", err)
+		}
+		applied = appliedEntries(entries, ms)
+	}
+
+	report := &StatusReport{
+		Version:    curr,
+		Migrations: make([]MigrationStatus, 0, len(ms)),
+		Ahead:      curr > lastVersion(ms),
+	}
+	if dm, ok := asMigrator[DirtyMigrator](m.Migrator); ok {
+		report.Dirty, err = dm.Dirty(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("get dirty: %w", err)
+		}
+	}
+	for _, mig := range ms {
+		status := MigrationStatus{
+			Migration: mig,
+			Applied:   mig.ID <= curr,
+		}
+		if applied != nil {
+			entry, ok := applied[mig.ID]
+			status.Applied = ok
+			status.AppliedAt = entry.CreatedAt
+		}
+		report.Migrations = append(report.Migrations, status)
+	}
+	return report, nil
+}
@@ -0,0 +1,83 @@
+package dbump_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cristalhq/dbump"
+	"github.com/cristalhq/dbump/tests"
+)
+
+func TestStatus(t *testing.T) {
+	appliedAt := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	mm := &tests.MockHistoryMigrator{
+		MockMigrator: &tests.MockMigrator{
+			VersionFn: func(ctx context.Context) (version int, err error) {
+				return 2, nil
+			},
+		},
+		HistoryFn: func(ctx context.Context) ([]dbump.LogEntry, error) {
+			return []dbump.LogEntry{
+				{Version: 1, CreatedAt: appliedAt},
+				{Version: 2, CreatedAt: appliedAt.Add(time.Hour)},
+				{Version: 3, CreatedAt: appliedAt.Add(2 * time.Hour)},
+				{Version: 2, CreatedAt: appliedAt.Add(3 * time.Hour)},
+			}, nil
+		},
+	}
+
+	report, err := dbump.Status(context.Background(), mm, dbump.NewSliceLoader(testdataMigrations))
+	failIfErr(t, err)
+
+	want := &dbump.StatusReport{
+		Version: 2,
+		Migrations: []dbump.MigrationStatus{
+			{Migration: testdataMigrations[0], Applied: true, AppliedAt: appliedAt},
+			{Migration: testdataMigrations[1], Applied: true, AppliedAt: appliedAt.Add(time.Hour)},
+			{Migration: testdataMigrations[2]},
+			{Migration: testdataMigrations[3]},
+			{Migration: testdataMigrations[4]},
+		},
+	}
+	mustEqual(t, report, want)
+	mustEqual(t, report.Pending(), testdataMigrations[2:])
+	mustEqual(t, mm.Log(), []string{"init", "getversion", "history"})
+}
+
+func TestStatusAhead(t *testing.T) {
+	mm := &tests.MockMigrator{
+		VersionFn: func(ctx context.Context) (version int, err error) {
+			return 7, nil
+		},
+	}
+
+	report, err := dbump.Status(context.Background(), mm, dbump.NewSliceLoader(testdataMigrations))
+	failIfErr(t, err)
+
+	mustEqual(t, report.Version, 7)
+	mustEqual(t, report.Ahead, true)
+	mustEqual(t, len(report.Pending()), 0)
+}
+
+func TestStatusDirty(t *testing.T) {
+	dirty := &dbump.LogEntry{Version: 2, MigrationID: 2, Direction: dbump.DirectionApply}
+
+	mm := &tests.MockDirtyMigrator{
+		MockHistoryMigrator: &tests.MockHistoryMigrator{
+			MockMigrator: &tests.MockMigrator{
+				VersionFn: func(ctx context.Context) (version int, err error) {
+					return 1, nil
+				},
+			},
+		},
+		DirtyFn: func(ctx context.Context) (*dbump.LogEntry, error) {
+			return dirty, nil
+		},
+	}
+
+	report, err := dbump.Status(context.Background(), mm, dbump.NewSliceLoader(testdataMigrations))
+	failIfErr(t, err)
+	mustEqual(t, report.Dirty, dirty)
+}
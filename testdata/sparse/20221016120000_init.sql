@@ -0,0 +1,3 @@
+SELECT 1;
+--- apply above / revert below ---
+SELECT 10;
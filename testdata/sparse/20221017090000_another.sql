@@ -0,0 +1,3 @@
+SELECT 2;
+--- apply above / revert below ---
+SELECT 20;
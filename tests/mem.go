@@ -0,0 +1,206 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cristalhq/dbump"
+)
+
+var (
+	_ dbump.HistoryMigrator    = &MemMigrator{}
+	_ dbump.TxMigrator         = &MemMigrator{}
+	_ dbump.VersionSetter      = &MemMigrator{}
+	_ dbump.DirtyMigrator      = &MemMigrator{}
+	_ dbump.RepeatableMigrator = &MemMigrator{}
+)
+
+// MemMigrator keeps the migration log in memory like SQL migrators keep it in a table.
+// Used to run MigratorSuite cases for the optional interfaces without a database.
+type MemMigrator struct {
+	mu      sync.Mutex
+	locked  bool
+	entries []memEntry
+
+	// ExecFn runs queries of the steps, default does nothing.
+	ExecFn func(ctx context.Context, query string) error
+}
+
+type memEntry struct {
+	dbump.LogEntry
+	started bool
+}
+
+func (mm *MemMigrator) LockDB(ctx context.Context) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if mm.locked {
+		return dbump.ErrMigrationAlreadyLocked
+	}
+	mm.locked = true
+	return nil
+}
+
+func (mm *MemMigrator) UnlockDB(ctx context.Context) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.locked = false
+	return nil
+}
+
+func (mm *MemMigrator) Init(ctx context.Context) error { return nil }
+
+func (mm *MemMigrator) Drop(ctx context.Context) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.entries = nil
+	return nil
+}
+
+func (mm *MemMigrator) Version(ctx context.Context) (version int, err error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for i := len(mm.entries) - 1; i >= 0; i-- {
+		if !mm.entries[i].started {
+			return mm.entries[i].Version, nil
+		}
+	}
+	return 0, nil
+}
+
+func (mm *MemMigrator) History(ctx context.Context) ([]dbump.LogEntry, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	var res []dbump.LogEntry
+	for _, e := range mm.entries {
+		if !e.started && e.Phase != dbump.PhaseRepeatable {
+			res = append(res, e.LogEntry)
+		}
+	}
+	return res, nil
+}
+
+func (mm *MemMigrator) SetChecksum(ctx context.Context, version int, checksum string) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	for i, e := range mm.entries {
+		if e.Phase != dbump.PhaseRepeatable &&
+			(e.MigrationID == version || (e.MigrationID == 0 && e.Version == version)) {
+			mm.entries[i].Checksum = checksum
+		}
+	}
+	return nil
+}
+
+func (mm *MemMigrator) SetVersion(ctx context.Context, version int, reason string) error {
+	mm.append(memEntry{LogEntry: dbump.LogEntry{Version: version, Reason: reason}})
+	return nil
+}
+
+func (mm *MemMigrator) Dirty(ctx context.Context) (*dbump.LogEntry, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	if len(mm.entries) == 0 || !mm.entries[len(mm.entries)-1].started {
+		return nil, nil
+	}
+	e := mm.entries[len(mm.entries)-1].LogEntry
+	return &e, nil
+}
+
+func (mm *MemMigrator) RepeatableChecksums(ctx context.Context) (map[string]string, error) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	checksums := map[string]string{}
+	for _, e := range mm.entries {
+		if !e.started && e.Phase == dbump.PhaseRepeatable {
+			checksums[e.Name] = e.Checksum
+		}
+	}
+	return checksums, nil
+}
+
+func (mm *MemMigrator) DoStep(ctx context.Context, step dbump.Step) error {
+	if step.DisableTx {
+		mm.append(memEntry{LogEntry: stepEntry(step), started: true})
+	}
+	return mm.doStep(ctx, step)
+}
+
+func (mm *MemMigrator) InTx(ctx context.Context, fn func(ctx context.Context, doStep func(ctx context.Context, step dbump.Step) error) error) error {
+	mm.mu.Lock()
+	snapshot := append([]memEntry(nil), mm.entries...)
+	mm.mu.Unlock()
+
+	if err := fn(ctx, mm.doStep); err != nil {
+		mm.mu.Lock()
+		mm.entries = snapshot
+		mm.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (mm *MemMigrator) doStep(ctx context.Context, step dbump.Step) error {
+	switch {
+	case step.Phase == dbump.PhaseSkipped:
+		// nothing to run, only the log is updated.
+	case step.Func != nil:
+		if err := step.Func(ctx, &memExecutor{mm: mm}); err != nil {
+			return err
+		}
+	default:
+		if err := mm.exec(ctx, step.Query); err != nil {
+			return err
+		}
+	}
+	mm.append(memEntry{LogEntry: stepEntry(step)})
+	return nil
+}
+
+func (mm *MemMigrator) exec(ctx context.Context, query string) error {
+	if mm.ExecFn == nil {
+		return nil
+	}
+	return mm.ExecFn(ctx, query)
+}
+
+func (mm *MemMigrator) append(e memEntry) {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	e.CreatedAt = time.Now()
+	mm.entries = append(mm.entries, e)
+}
+
+func stepEntry(step dbump.Step) dbump.LogEntry {
+	return dbump.LogEntry{
+		Version:     step.Version,
+		MigrationID: step.MigrationID,
+		Name:        step.Name,
+		Direction:   step.Direction,
+		Phase:       step.Phase,
+		Checksum:    step.Checksum,
+	}
+}
+
+type memExecutor struct {
+	mm *MemMigrator
+}
+
+func (e *memExecutor) Exec(ctx context.Context, query string, args ...interface{}) error {
+	return e.mm.exec(ctx, query)
+}
+
+func (e *memExecutor) Query(ctx context.Context, query string, args ...interface{}) (dbump.Rows, error) {
+	return nil, errors.New("query is not supported")
+}
@@ -9,7 +9,14 @@ import (
 
 const mockDoStepFmt = "{v:%d q:'%s' notx:%v}"
 
-var _ dbump.Migrator = &MockMigrator{}
+var (
+	_ dbump.Migrator           = &MockMigrator{}
+	_ dbump.HistoryMigrator    = &MockHistoryMigrator{}
+	_ dbump.VersionSetter      = &MockHistoryMigrator{}
+	_ dbump.TxMigrator         = &MockTxMigrator{}
+	_ dbump.DirtyMigrator      = &MockDirtyMigrator{}
+	_ dbump.RepeatableMigrator = &MockRepeatableMigrator{}
+)
 
 type MockMigrator struct {
 	log []string
@@ -86,3 +93,77 @@ func (mm *MockMigrator) DoStep(ctx context.Context, step dbump.Step) error {
 	}
 	return mm.DoStepFn(ctx, step)
 }
+
+type MockHistoryMigrator struct {
+	*MockMigrator
+
+	HistoryFn     func(ctx context.Context) ([]dbump.LogEntry, error)
+	SetChecksumFn func(ctx context.Context, version int, checksum string) error
+	SetVersionFn  func(ctx context.Context, version int, reason string) error
+}
+
+func (mm *MockHistoryMigrator) History(ctx context.Context) ([]dbump.LogEntry, error) {
+	mm.log = append(mm.log, "history")
+	if mm.HistoryFn == nil {
+		return nil, nil
+	}
+	return mm.HistoryFn(ctx)
+}
+
+func (mm *MockHistoryMigrator) SetChecksum(ctx context.Context, version int, checksum string) error {
+	mm.log = append(mm.log, "setchecksum", fmt.Sprintf("{v:%d c:%s}", version, checksum))
+	if mm.SetChecksumFn == nil {
+		return nil
+	}
+	return mm.SetChecksumFn(ctx, version, checksum)
+}
+
+func (mm *MockHistoryMigrator) SetVersion(ctx context.Context, version int, reason string) error {
+	mm.log = append(mm.log, "setversion", fmt.Sprintf("{v:%d r:%s}", version, reason))
+	if mm.SetVersionFn == nil {
+		return nil
+	}
+	return mm.SetVersionFn(ctx, version, reason)
+}
+
+type MockTxMigrator struct {
+	*MockMigrator
+}
+
+func (mm *MockTxMigrator) InTx(ctx context.Context, fn func(ctx context.Context, doStep func(ctx context.Context, step dbump.Step) error) error) error {
+	mm.log = append(mm.log, "begin")
+	if err := fn(ctx, mm.DoStep); err != nil {
+		mm.log = append(mm.log, "rollback")
+		return err
+	}
+	mm.log = append(mm.log, "commit")
+	return nil
+}
+
+type MockDirtyMigrator struct {
+	*MockHistoryMigrator
+
+	DirtyFn func(ctx context.Context) (*dbump.LogEntry, error)
+}
+
+func (mm *MockDirtyMigrator) Dirty(ctx context.Context) (*dbump.LogEntry, error) {
+	mm.log = append(mm.log, "dirty")
+	if mm.DirtyFn == nil {
+		return nil, nil
+	}
+	return mm.DirtyFn(ctx)
+}
+
+type MockRepeatableMigrator struct {
+	*MockMigrator
+
+	RepeatableChecksumsFn func(ctx context.Context) (map[string]string, error)
+}
+
+func (mm *MockRepeatableMigrator) RepeatableChecksums(ctx context.Context) (map[string]string, error) {
+	mm.log = append(mm.log, "repeatablechecksums")
+	if mm.RepeatableChecksumsFn == nil {
+		return nil, nil
+	}
+	return mm.RepeatableChecksumsFn(ctx)
+}
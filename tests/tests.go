@@ -2,6 +2,7 @@ package tests
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -15,6 +16,8 @@ type MigratorSuite struct {
 	RevertTmpl   string
 	CleanMigTmpl string
 	CleanTest    string
+	// FailQuery must fail on every run.
+	FailQuery string
 }
 
 func NewMigratorSuite(m dbump.Migrator) *MigratorSuite {
@@ -23,6 +26,7 @@ func NewMigratorSuite(m dbump.Migrator) *MigratorSuite {
 		// some default harmless queries
 		ApplyTmpl:  "SELECT %[2]d;",
 		RevertTmpl: "SELECT %[2]d0;",
+		FailQuery:  "SELECT * FROM dbump_no_such_table;",
 	}
 }
 
@@ -155,6 +159,48 @@ func (suite *MigratorSuite) Redo(t *testing.T) {
 	mustEqual(t, mig.Log(), wantLog)
 }
 
+func (suite *MigratorSuite) ApplyTo(t *testing.T) {
+	migs := suite.genMigrations(t, 5, "apply_to")
+	suite.prepare(t, migs[:1])
+
+	wantLog := []string{"lockdb", "init", "getversion"}
+	for _, m := range migs[1:4] {
+		v := fmt.Sprintf(mockDoStepFmt, m.ID, m.Apply, false)
+		wantLog = append(wantLog, "dostep", v)
+	}
+	wantLog = append(wantLog, "unlockdb")
+
+	mig := suite.getMockedMigrator()
+	failIfErr(t, dbump.Run(context.Background(), dbump.Config{
+		Migrator: mig,
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeApplyTo,
+		Version:  4,
+	}))
+	mustEqual(t, mig.Log(), wantLog)
+}
+
+func (suite *MigratorSuite) RevertTo(t *testing.T) {
+	migs := suite.genMigrations(t, 5, "revert_to")
+	suite.prepare(t, migs[:4])
+
+	wantLog := []string{"lockdb", "init", "getversion"}
+	for _, m := range reverse(migs[2:4]) {
+		v := fmt.Sprintf(mockDoStepFmt, m.ID-1, m.Revert, false)
+		wantLog = append(wantLog, "dostep", v)
+	}
+	wantLog = append(wantLog, "unlockdb")
+
+	mig := suite.getMockedMigrator()
+	failIfErr(t, dbump.Run(context.Background(), dbump.Config{
+		Migrator: mig,
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeRevertTo,
+		Version:  2,
+	}))
+	mustEqual(t, mig.Log(), wantLog)
+}
+
 func (suite *MigratorSuite) Drop(t *testing.T) {
 	migs := suite.genMigrations(t, 5, "drop")
 	suite.prepare(t, migs)
@@ -175,6 +221,329 @@ func (suite *MigratorSuite) Drop(t *testing.T) {
 	mustEqual(t, mig.Log(), wantLog)
 }
 
+// ChecksumDrift of applied migrations and their repair, Migrator must implement dbump.HistoryMigrator.
+func (suite *MigratorSuite) ChecksumDrift(t *testing.T) {
+	ctx := context.Background()
+	hm := optional[dbump.HistoryMigrator](t, suite.migrator)
+
+	migs := suite.genMigrations(t, 3, "checksum_drift")
+	suite.prepare(t, migs)
+
+	edited := cloneMigrations(migs)
+	edited[1].Checksum = "edited"
+	cfg := dbump.Config{
+		Migrator: suite.migrator,
+		Loader:   dbump.NewSliceLoader(edited),
+		Mode:     dbump.ModeApplyAll,
+	}
+
+	var errChecksum *dbump.ChecksumError
+	if err := dbump.Run(ctx, cfg); !errors.As(err, &errChecksum) {
+		t.Fatalf("want ChecksumError, got %v", err)
+	}
+	mustEqual(t, len(errChecksum.Migrations), 1)
+	mustEqual(t, errChecksum.Migrations[0].ID, 2)
+
+	cfg.Mode = dbump.ModeRepairChecksums
+	failIfErr(t, dbump.Run(ctx, cfg))
+
+	entries, err := hm.History(ctx)
+	failIfErr(t, err)
+
+	checksums := map[int]string{}
+	for _, e := range entries {
+		checksums[e.MigrationID] = e.Checksum
+	}
+	mustEqual(t, checksums, map[int]string{1: migs[0].Checksum, 2: "edited", 3: migs[2].Checksum})
+
+	cfg.Mode = dbump.ModeApplyAll
+	failIfErr(t, dbump.Run(ctx, cfg))
+}
+
+// HistoryReplay of the stored steps, Migrator must implement dbump.HistoryMigrator.
+func (suite *MigratorSuite) HistoryReplay(t *testing.T) {
+	ctx := context.Background()
+	hm := optional[dbump.HistoryMigrator](t, suite.migrator)
+
+	migs := suite.genMigrations(t, 3, "history_replay")
+	suite.prepare(t, migs[:2])
+
+	failIfErr(t, dbump.Run(ctx, dbump.Config{
+		Migrator: suite.getMockedMigrator(),
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeApplyAll,
+		ZigZag:   true,
+	}))
+	failIfErr(t, dbump.Run(ctx, dbump.Config{
+		Migrator: suite.getMockedMigrator(),
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeRevertN,
+		Num:      1,
+	}))
+
+	entries, err := hm.History(ctx)
+	failIfErr(t, err)
+
+	type entry struct {
+		ID        int
+		Direction dbump.Direction
+		Phase     dbump.Phase
+		Version   int
+	}
+	var got []entry
+	for _, e := range entries {
+		got = append(got, entry{e.MigrationID, e.Direction, e.Phase, e.Version})
+	}
+	mustEqual(t, got, []entry{
+		{1, dbump.DirectionApply, dbump.PhaseMain, 1},
+		{2, dbump.DirectionApply, dbump.PhaseMain, 2},
+		{3, dbump.DirectionApply, dbump.PhaseMain, 3},
+		{3, dbump.DirectionRevert, dbump.PhaseZigZag, 2},
+		{3, dbump.DirectionApply, dbump.PhaseZigZag, 3},
+		{3, dbump.DirectionRevert, dbump.PhaseMain, 2},
+	})
+
+	report, err := dbump.Status(ctx, suite.migrator, dbump.NewSliceLoader(migs))
+	failIfErr(t, err)
+	mustEqual(t, report.Version, 2)
+	mustEqual(t, appliedFlags(report), []bool{true, true, false})
+}
+
+// SingleTx rollback of all steps, Migrator must implement dbump.TxMigrator.
+func (suite *MigratorSuite) SingleTx(t *testing.T) {
+	ctx := context.Background()
+	optional[dbump.TxMigrator](t, suite.migrator)
+
+	migs := suite.genMigrations(t, 3, "single_tx")
+	apply := migs[2].Apply
+	migs[2].Apply = suite.FailQuery
+
+	cfg := dbump.Config{
+		Migrator: suite.migrator,
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeApplyAll,
+		SingleTx: true,
+	}
+	failIfOk(t, dbump.Run(ctx, cfg))
+
+	version, err := suite.migrator.Version(ctx)
+	failIfErr(t, err)
+	mustEqual(t, version, 0)
+
+	// rolled back steps can be applied again.
+	migs[2].Apply, migs[2].Checksum = apply, ""
+	failIfErr(t, dbump.Run(ctx, cfg))
+
+	version, err = suite.migrator.Version(ctx)
+	failIfErr(t, err)
+	mustEqual(t, version, 3)
+}
+
+// Baseline of a database without migration log, Migrator must implement dbump.VersionSetter.
+func (suite *MigratorSuite) Baseline(t *testing.T) {
+	ctx := context.Background()
+	optional[dbump.VersionSetter](t, suite.migrator)
+
+	migs := suite.genMigrations(t, 4, "baseline")
+	failIfErr(t, dbump.Run(ctx, dbump.Config{
+		Migrator: suite.migrator,
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeBaseline,
+		Version:  2,
+	}))
+
+	version, err := suite.migrator.Version(ctx)
+	failIfErr(t, err)
+	mustEqual(t, version, 2)
+
+	wantLog := []string{"lockdb", "init", "getversion"}
+	for _, m := range migs[2:] {
+		v := fmt.Sprintf(mockDoStepFmt, m.ID, m.Apply, false)
+		wantLog = append(wantLog, "dostep", v)
+	}
+	wantLog = append(wantLog, "unlockdb")
+
+	mig := suite.getMockedMigrator()
+	failIfErr(t, dbump.Run(ctx, dbump.Config{
+		Migrator: mig,
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeApplyAll,
+	}))
+	mustEqual(t, mig.Log(), wantLog)
+}
+
+// ForceVersion with a reason, Migrator must implement dbump.VersionSetter.
+func (suite *MigratorSuite) ForceVersion(t *testing.T) {
+	ctx := context.Background()
+	optional[dbump.VersionSetter](t, suite.migrator)
+
+	migs := suite.genMigrations(t, 3, "force_version")
+	suite.prepare(t, migs)
+
+	failIfErr(t, dbump.Run(ctx, dbump.Config{
+		Migrator: suite.migrator,
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeForceVersion,
+		Version:  1,
+		Reason:   "fixed by hand",
+	}))
+
+	version, err := suite.migrator.Version(ctx)
+	failIfErr(t, err)
+	mustEqual(t, version, 1)
+
+	hm, ok := suite.migrator.(dbump.HistoryMigrator)
+	if !ok {
+		return
+	}
+	entries, err := hm.History(ctx)
+	failIfErr(t, err)
+	last := entries[len(entries)-1]
+	mustEqual(t, last.Version, 1)
+	mustEqual(t, last.Reason, "fixed by hand")
+
+	report, err := dbump.Status(ctx, suite.migrator, dbump.NewSliceLoader(migs))
+	failIfErr(t, err)
+	mustEqual(t, appliedFlags(report), []bool{true, false, false})
+}
+
+// Dirty step without a transaction, Migrator must implement dbump.DirtyMigrator.
+func (suite *MigratorSuite) Dirty(t *testing.T) {
+	ctx := context.Background()
+	dm := optional[dbump.DirtyMigrator](t, suite.migrator)
+
+	migs := suite.genMigrations(t, 2, "dirty")
+	migs[1].Apply = suite.FailQuery
+
+	failIfOk(t, dbump.Run(ctx, dbump.Config{
+		Migrator:  suite.getMockedMigrator(),
+		Loader:    dbump.NewSliceLoader(migs),
+		Mode:      dbump.ModeApplyAll,
+		DisableTx: true,
+	}))
+
+	entry, err := dm.Dirty(ctx)
+	failIfErr(t, err)
+	if entry == nil {
+		t.Fatal("want dirty entry")
+	}
+	mustEqual(t, entry.MigrationID, 2)
+
+	cfg := dbump.Config{
+		Migrator: suite.migrator,
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeApplyAll,
+	}
+	var errDirty *dbump.DirtyError
+	if err := dbump.Run(ctx, cfg); !errors.As(err, &errDirty) {
+		t.Fatalf("want DirtyError, got %v", err)
+	}
+
+	if _, ok := suite.migrator.(dbump.VersionSetter); !ok {
+		return
+	}
+	cfg.Mode = dbump.ModeForceVersion
+	cfg.Version = 1
+	cfg.Reason = "fixed by hand"
+	failIfErr(t, dbump.Run(ctx, cfg))
+
+	entry, err = dm.Dirty(ctx)
+	failIfErr(t, err)
+	mustEqual(t, entry, (*dbump.LogEntry)(nil))
+}
+
+// Repeatable migration is applied when changed, Migrator must implement dbump.RepeatableMigrator.
+func (suite *MigratorSuite) Repeatable(t *testing.T) {
+	ctx := context.Background()
+	rm := optional[dbump.RepeatableMigrator](t, suite.migrator)
+
+	migs := suite.genMigrations(t, 2, "repeatable")
+	run := func(query string) {
+		t.Helper()
+		failIfErr(t, dbump.Run(ctx, dbump.Config{
+			Migrator: suite.migrator,
+			Loader: dbump.NewSliceLoader(append(migs[:2:2], &dbump.Migration{
+				Name:       "R_repeatable",
+				Apply:      query,
+				Repeatable: true,
+			})),
+			Mode: dbump.ModeApplyAll,
+		}))
+
+		version, err := suite.migrator.Version(ctx)
+		failIfErr(t, err)
+		mustEqual(t, version, 2)
+
+		checksums, err := rm.RepeatableChecksums(ctx)
+		failIfErr(t, err)
+		mustEqual(t, checksums, map[string]string{"R_repeatable": dbump.Checksum(query, "")})
+	}
+
+	run("SELECT 1;")
+	run("SELECT 1;")
+	run("SELECT 2;")
+
+	hm, ok := suite.migrator.(dbump.HistoryMigrator)
+	if !ok {
+		return
+	}
+	entries, err := hm.History(ctx)
+	failIfErr(t, err)
+	mustEqual(t, len(entries), 2)
+}
+
+// UpgradeLegacyTable written by previous versions, setup must create it with a row for version 2.
+// Migrator must implement dbump.HistoryMigrator.
+func (suite *MigratorSuite) UpgradeLegacyTable(t *testing.T, setup func(ctx context.Context) error) {
+	ctx := context.Background()
+	hm := optional[dbump.HistoryMigrator](t, suite.migrator)
+
+	migs := suite.genMigrations(t, 3, "legacy_table")
+	failIfErr(t, suite.migrator.Drop(ctx))
+	failIfErr(t, setup(ctx))
+	failIfErr(t, suite.migrator.Init(ctx))
+
+	version, err := suite.migrator.Version(ctx)
+	failIfErr(t, err)
+	mustEqual(t, version, 2)
+
+	entries, err := hm.History(ctx)
+	failIfErr(t, err)
+	mustEqual(t, len(entries), 1)
+	mustEqual(t, entries[0].MigrationID, 0)
+	mustEqual(t, entries[0].Version, 2)
+
+	if dm, ok := suite.migrator.(dbump.DirtyMigrator); ok {
+		dirty, err := dm.Dirty(ctx)
+		failIfErr(t, err)
+		mustEqual(t, dirty, (*dbump.LogEntry)(nil))
+	}
+
+	report, err := dbump.Status(ctx, suite.migrator, dbump.NewSliceLoader(migs))
+	failIfErr(t, err)
+	mustEqual(t, report.Version, 2)
+	mustEqual(t, appliedFlags(report), []bool{true, true, false})
+
+	// checksums of the legacy rows are filled on repair.
+	cfg := dbump.Config{
+		Migrator: suite.migrator,
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeRepairChecksums,
+	}
+	failIfErr(t, dbump.Run(ctx, cfg))
+
+	entries, err = hm.History(ctx)
+	failIfErr(t, err)
+	mustEqual(t, entries[0].Checksum, migs[1].Checksum)
+
+	cfg.Mode = dbump.ModeApplyAll
+	failIfErr(t, dbump.Run(ctx, cfg))
+
+	version, err = suite.migrator.Version(ctx)
+	failIfErr(t, err)
+	mustEqual(t, version, 3)
+}
+
 // TODO:
 // func TestTimeout(t *testing.T) {
 // 	wantLog := []string{
@@ -243,10 +612,38 @@ func (suite *MigratorSuite) genMigrations(tb testing.TB, num int, testname strin
 		failIfErr(tb, suite.migrator.DoStep(context.Background(), dbump.Step{
 			Query: suite.CleanTest,
 		}))
+		failIfErr(tb, suite.migrator.Drop(context.Background()))
 	})
 	return res
 }
 
+// optional interface of the migrator, test is skipped when it is not implemented.
+func optional[T any](t *testing.T, m dbump.Migrator) T {
+	t.Helper()
+	v, ok := m.(T)
+	if !ok {
+		t.Skipf("migrator does not implement %T", (*T)(nil))
+	}
+	return v
+}
+
+func cloneMigrations(migs []*dbump.Migration) []*dbump.Migration {
+	res := make([]*dbump.Migration, 0, len(migs))
+	for _, m := range migs {
+		clone := *m
+		res = append(res, &clone)
+	}
+	return res
+}
+
+func appliedFlags(report *dbump.StatusReport) []bool {
+	res := make([]bool, 0, len(report.Migrations))
+	for _, ms := range report.Migrations {
+		res = append(res, ms.Applied)
+	}
+	return res
+}
+
 func reverse(migs []*dbump.Migration) []*dbump.Migration {
 	res := make([]*dbump.Migration, len(migs))
 	for i := range migs {
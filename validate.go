@@ -0,0 +1,203 @@
+package dbump
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ValidationError lists all the problems found by Validate.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid migrations:\n\t" + strings.Join(e.Problems, "\n\t")
+}
+
+// Validate migrations provided by the Loader without a database.
+// All the problems are reported at once in ValidationError:
+// missing or duplicated IDs, empty apply or revert parts (except irreversible migrations), files that look like migrations
+// but are skipped by the loader, inconsistent zero-padding and misspelled delimiters.
+// IDs are expected to be 1, 2, 3 and so on, see ModeValidate to validate with Config.SparseIDs.
+func Validate(loader Loader) error {
+	if loader == nil {
+		return fmt.Errorf("loader cannot be nil")
+	}
+	return validate(loader, false)
+}
+
+func validate(loader Loader, sparseIDs bool) error {
+	ms, problems := validateLoader(loader)
+	problems = append(problems, validateMigrations(ms, sparseIDs)...)
+
+	if len(problems) != 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// filesValidator is implemented by loaders that can check their files
+// and load all the correct migrations instead of stopping on the first error.
+type filesValidator interface {
+	validateFiles() ([]*Migration, []string)
+}
+
+func validateLoader(loader Loader) ([]*Migration, []string) {
+	switch l := loader.(type) {
+	case filesValidator:
+		return l.validateFiles()
+
+	case *MultiLoader:
+		var ms []*Migration
+		var problems []string
+		for _, sub := range l.loaders {
+			subMs, subProblems := validateLoader(sub)
+			ms = append(ms, subMs...)
+			problems = append(problems, subProblems...)
+		}
+		return ms, problems
+
+	default:
+		ms, err := loader.Load()
+		if err != nil {
+			return nil, []string{fmt.Sprintf("load: %s", err)}
+		}
+		return ms, nil
+	}
+}
+
+func validateMigrations(all []*Migration, sparseIDs bool) []string {
+	var problems []string
+	var ms []*Migration
+	names := map[string]bool{}
+	for _, m := range all {
+		if !m.Repeatable {
+			ms = append(ms, m)
+			continue
+		}
+
+		switch {
+		case m.Name == "":
+			problems = append(problems, "repeatable migration must have a name")
+		case names[m.Name]:
+			problems = append(problems, fmt.Sprintf("%s: duplicate repeatable migration", m.Name))
+		}
+		names[m.Name] = true
+
+		if m.Apply == "" && m.ApplyFunc == nil {
+			problems = append(problems, fmt.Sprintf("%s: apply part is empty", m.Name))
+		}
+		if m.Revert != "" || m.RevertFunc != nil {
+			problems = append(problems, fmt.Sprintf("%s: repeatable migration cannot be reverted", m.Name))
+		}
+	}
+
+	sort.SliceStable(ms, func(i, j int) bool {
+		return ms[i].ID < ms[j].ID
+	})
+
+	for i, m := range ms {
+		switch {
+		case m.ID <= 0:
+			problems = append(problems, fmt.Sprintf("%s: migration number must be positive: %d", m.Name, m.ID))
+		case i > 0 && m.ID == ms[i-1].ID:
+			problems = append(problems, fmt.Sprintf("%s: duplicate migration number %d (%s)", m.Name, m.ID, ms[i-1].Name))
+		case !sparseIDs && i > 0 && m.ID > ms[i-1].ID+1:
+			problems = append(problems, missingIDs(ms[i-1].ID+1, m.ID-1))
+		case !sparseIDs && i == 0 && m.ID > 1:
+			problems = append(problems, missingIDs(1, m.ID-1))
+		}
+
+		if m.Apply == "" && m.ApplyFunc == nil {
+			problems = append(problems, fmt.Sprintf("%s: apply part is empty", m.Name))
+		}
+		if m.Revert == "" && m.RevertFunc == nil && !m.Irreversible {
+			problems = append(problems, fmt.Sprintf("%s: revert part is empty", m.Name))
+		}
+	}
+	return problems
+}
+
+func missingIDs(from, to int) string {
+	if from == to {
+		return fmt.Sprintf("missing migration number: %d", from)
+	}
+	return fmt.Sprintf("missing migration numbers: %d-%d", from, to)
+}
+
+// looksLikeMigrationRE matches files that probably should be migrations.
+var looksLikeMigrationRE = regexp.MustCompile(`(?i)\.sql$`)
+
+// delimiterTypoRE matches lines that are probably misspelled MigrationDelimiter.
+var delimiterTypoRE = regexp.MustCompile(`(?i)^\s*-{2,}.*\b(apply|revert)\b.*\b(apply|revert)\b`)
+
+func validateFS(fsys FS, dir string) ([]*Migration, []string) {
+	files, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, []string{fmt.Sprintf("read dir: %s", err)}
+	}
+
+	var ms []*Migration
+	var problems []string
+	var names, ids []string
+
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		name := fi.Name()
+
+		matches := migrationRE.FindStringSubmatch(name)
+		repeatable := repeatableRE.MatchString(name)
+		if len(matches) != 2 && !repeatable {
+			if looksLikeMigrationRE.MatchString(name) {
+				problems = append(problems, fmt.Sprintf("%s: looks like a migration but is skipped, name must be like 0001_name.sql or R_name.sql", name))
+			}
+			continue
+		}
+		if !repeatable {
+			names, ids = append(names, name), append(ids, matches[1])
+		}
+
+		body, err := fsys.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+			continue
+		}
+
+		for i, line := range strings.Split(string(body), "\n") {
+			if strings.TrimSpace(line) != MigrationDelimiter && delimiterTypoRE.MatchString(line) {
+				problems = append(problems, fmt.Sprintf("%s: line %d looks like a misspelled delimiter", name, i+1))
+			}
+		}
+
+		var m *Migration
+		if repeatable {
+			m, err = newRepeatableMigration(name, body)
+		} else {
+			m, err = newMigration(matches[1], name, body)
+		}
+		if err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		ms = append(ms, m)
+	}
+
+	// when one number is zero-padded all of them should have the same width.
+	for _, id := range ids {
+		if len(id) < 2 || id[0] != '0' {
+			continue
+		}
+		for i := range ids {
+			if len(ids[i]) != len(id) {
+				problems = append(problems, fmt.Sprintf("%s: number has %d digits but %d is expected", names[i], len(ids[i]), len(id)))
+			}
+		}
+		break
+	}
+	return ms, problems
+}
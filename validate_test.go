@@ -0,0 +1,105 @@
+package dbump_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/cristalhq/dbump"
+)
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		testName     string
+		loader       dbump.Loader
+		wantProblems []string
+	}{
+		{
+			testName: "testdata",
+			loader:   dbump.NewDiskLoader("./testdata"),
+			wantProblems: []string{
+				"a007_spy.sql: looks like a migration but is skipped, name must be like 0001_name.sql or R_name.sql",
+			},
+		},
+		{
+			testName: "delimiter typo",
+			loader:   dbump.NewDiskLoader("./testdata/bad"),
+			wantProblems: []string{
+				"0001_init.sql: line 4 looks like a misspelled delimiter",
+				"0001_init.sql: should have 2 parts separated by MigrationDelimiter but got: 1",
+			},
+		},
+		{
+			testName: "numbers",
+			loader: dbump.NewFileSysLoader(fstest.MapFS{
+				"0001_a.sql": {Data: []byte("SELECT 1;\n--- apply above / revert below ---\nSELECT 1;")},
+				"0002_b.sql": {Data: []byte("SELECT 2;\n--- apply above / revert below ---\nSELECT 2;")},
+				"02_c.sql":   {Data: []byte("SELECT 2;\n--- apply above / revert below ---\nSELECT 2;")},
+				"5_d.sql":    {Data: []byte("SELECT 5;\n--- apply above / revert below ---\nSELECT 5;")},
+			}, "."),
+			wantProblems: []string{
+				"02_c.sql: number has 2 digits but 4 is expected",
+				"5_d.sql: number has 1 digits but 4 is expected",
+				"02_c.sql: duplicate migration number 2 (0002_b.sql)",
+				"missing migration numbers: 3-4",
+			},
+		},
+		{
+			testName: "empty parts",
+			loader: dbump.NewSliceLoader([]*dbump.Migration{
+				{ID: 1, Name: "0001_a.sql", Apply: "SELECT 1;"},
+				{ID: 2, Name: "0002_b.sql", Revert: "SELECT 2;"},
+				{ID: 3, Name: "0003_c.sql", Apply: "SELECT 3;", Irreversible: true},
+			}),
+			wantProblems: []string{
+				"0001_a.sql: revert part is empty",
+				"0002_b.sql: apply part is empty",
+			},
+		},
+		{
+			testName: "nil apply function",
+			loader: func() dbump.Loader {
+				loader := dbump.NewSliceLoader(nil)
+				loader.AddFuncMigration(1, "0001_backfill.go", nil, func(ctx context.Context, exec dbump.Executor) error {
+					return nil
+				})
+				return loader
+			}(),
+			wantProblems: []string{
+				"0001_backfill.go: apply part is empty",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			err := dbump.Validate(tc.loader)
+
+			var errValidation *dbump.ValidationError
+			if !errors.As(err, &errValidation) {
+				t.Fatalf("want ValidationError, got %v", err)
+			}
+			mustEqual(t, errValidation.Problems, tc.wantProblems)
+		})
+	}
+}
+
+func TestModeValidate(t *testing.T) {
+	cfg := dbump.Config{
+		Loader:    dbump.NewDiskLoader("./testdata/sparse"),
+		Mode:      dbump.ModeValidate,
+		SparseIDs: true,
+	}
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+
+	plan, err := dbump.Plan(context.Background(), cfg)
+	failIfErr(t, err)
+	mustEqual(t, len(plan.Steps), 0)
+
+	cfg.SparseIDs = false
+	var errValidation *dbump.ValidationError
+	if err := dbump.Run(context.Background(), cfg); !errors.As(err, &errValidation) {
+		t.Fatalf("want ValidationError, got %v", err)
+	}
+}
@@ -0,0 +1,147 @@
+package dbump
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ExpandVars replaces `${name}` placeholders in the query with values from vars.
+// Undefined variable is an error.
+// Placeholders are replaced in quoted strings and identifiers too, dollar signs are not special there.
+// Comments and dollar-quoted strings (Postgres function bodies) are left as is,
+// placeholders inside them are replaced only when escaped with a backslash: `\${name}`.
+func ExpandVars(query string, vars map[string]string) (string, error) {
+	return expandVars(query, vars, false)
+}
+
+func expandVars(query string, vars map[string]string, inBody bool) (string, error) {
+	var b strings.Builder
+	b.Grow(len(query))
+
+	for i := 0; i < len(query); {
+		escaped := strings.HasPrefix(query[i:], `\${`)
+
+		switch {
+		case escaped || (!inBody && strings.HasPrefix(query[i:], "${")):
+			if escaped {
+				i++
+			}
+			value, n, err := lookupVar(query[i:], vars)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+			i += n
+
+		case !inBody && (query[i] == '\'' || query[i] == '"' || query[i] == '`'):
+			end, ok := skipQuoted(query[i:])
+			if !ok {
+				return "", fmt.Errorf("unterminated quote at %d", i)
+			}
+			if err := expandQuoted(&b, query[i:i+end], vars); err != nil {
+				return "", err
+			}
+			i += end
+
+		case !inBody && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end == -1 {
+				end = len(query) - i
+			}
+			if err := expandVerbatim(&b, query[i:i+end], vars); err != nil {
+				return "", err
+			}
+			i += end
+
+		case !inBody && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end == -1 {
+				return "", fmt.Errorf("unterminated comment at %d", i)
+			}
+			end += 4
+			if err := expandVerbatim(&b, query[i:i+end], vars); err != nil {
+				return "", err
+			}
+			i += end
+
+		case !inBody && query[i] == '$':
+			tag, ok := dollarTag(query[i:])
+			if !ok {
+				b.WriteByte(query[i])
+				i++
+				continue
+			}
+			start := i + len(tag)
+			end := strings.Index(query[start:], tag)
+			if end == -1 {
+				return "", fmt.Errorf("unterminated dollar-quoted string at %d", i)
+			}
+			body, err := expandVars(query[start:start+end], vars, true)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(tag)
+			b.WriteString(body)
+			b.WriteString(tag)
+			i = start + end + len(tag)
+
+		default:
+			b.WriteByte(query[i])
+			i++
+		}
+	}
+	return b.String(), nil
+}
+
+// expandQuoted writes quoted string s with replaced placeholders, `$tag$` is not a dollar-quote inside it.
+func expandQuoted(b *strings.Builder, s string, vars map[string]string) error {
+	for i := 0; i < len(s); {
+		escaped := strings.HasPrefix(s[i:], `\${`)
+		if !escaped && !strings.HasPrefix(s[i:], "${") {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		if escaped {
+			i++
+		}
+		value, n, err := lookupVar(s[i:], vars)
+		if err != nil {
+			return err
+		}
+		b.WriteString(value)
+		i += n
+	}
+	return nil
+}
+
+// expandVerbatim writes s where only escaped placeholders are replaced.
+func expandVerbatim(b *strings.Builder, s string, vars map[string]string) error {
+	value, err := expandVars(s, vars, true)
+	if err != nil {
+		return err
+	}
+	b.WriteString(value)
+	return nil
+}
+
+// lookupVar returns the value of the `${name}` placeholder at the beginning of s and its length.
+func lookupVar(s string, vars map[string]string) (string, int, error) {
+	end := strings.IndexByte(s, '}')
+	if end == -1 {
+		return "", 0, errors.New("unterminated variable")
+	}
+
+	name := s[len("${"):end]
+	if name == "" || len(readWord(name)) != len(name) || strings.Contains(name, "$") {
+		return "", 0, fmt.Errorf("invalid variable name: %q", name)
+	}
+
+	value, ok := vars[name]
+	if !ok {
+		return "", 0, fmt.Errorf("undefined variable: %q", name)
+	}
+	return value, end + 1, nil
+}
@@ -0,0 +1,120 @@
+package dbump_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cristalhq/dbump"
+	"github.com/cristalhq/dbump/tests"
+)
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{
+		"schema":         "tenant_1",
+		"cluster":        "main",
+		"retention_days": "30",
+	}
+
+	testCases := []struct {
+		testName string
+		query    string
+		want     string
+	}{
+		{
+			testName: "no vars",
+			query:    "SELECT $1, '$'",
+			want:     "SELECT $1, '$'",
+		},
+		{
+			testName: "vars",
+			query:    "CREATE TABLE ${schema}.t ON CLUSTER '${cluster}' TTL d + INTERVAL ${retention_days} DAY",
+			want:     "CREATE TABLE tenant_1.t ON CLUSTER 'main' TTL d + INTERVAL 30 DAY",
+		},
+		{
+			testName: "quoted",
+			query:    "CREATE TABLE \"${schema}\".t ENGINE = ReplicatedMergeTree('/ch/${cluster}/t', '$a$') AS SELECT `${schema}`",
+			want:     "CREATE TABLE \"tenant_1\".t ENGINE = ReplicatedMergeTree('/ch/main/t', '$a$') AS SELECT `tenant_1`",
+		},
+		{
+			testName: "xor operator",
+			query:    "SELECT a # ${retention_days}",
+			want:     "SELECT a # 30",
+		},
+		{
+			testName: "comments",
+			query:    "-- ${name}\nSELECT 1 /* ${name} $a$ */ FROM ${schema}.t",
+			want:     "-- ${name}\nSELECT 1 /* ${name} $a$ */ FROM tenant_1.t",
+		},
+		{
+			testName: "dollar-quoted body",
+			query:    "CREATE FUNCTION ${schema}.f() RETURNS text AS $$ SELECT '${name}' $$ LANGUAGE sql",
+			want:     "CREATE FUNCTION tenant_1.f() RETURNS text AS $$ SELECT '${name}' $$ LANGUAGE sql",
+		},
+		{
+			testName: "escaped in dollar-quoted body",
+			query:    `DO $body$ BEGIN SET search_path TO \${schema}; END $body$`,
+			want:     `DO $body$ BEGIN SET search_path TO tenant_1; END $body$`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testName, func(t *testing.T) {
+			query, err := dbump.ExpandVars(tc.query, vars)
+			failIfErr(t, err)
+			mustEqual(t, query, tc.want)
+		})
+	}
+}
+
+func TestExpandVarsErrors(t *testing.T) {
+	queries := []string{
+		"SELECT ${undefined}",
+		"SELECT ${schema",
+		"SELECT ${}",
+		"SELECT ${sch ema}",
+		"SELECT $tag$ ${schema}",
+		`SELECT $$ \${undefined} $$`,
+		"SELECT '${schema}",
+		"SELECT '${undefined}'",
+		`SELECT "${undefined}".t`,
+		"SELECT 1 /* ${schema}",
+	}
+
+	for _, query := range queries {
+		_, err := dbump.ExpandVars(query, map[string]string{"schema": "public"})
+		failIfOk(t, err)
+	}
+}
+
+func TestVars(t *testing.T) {
+	wantLog := []string{
+		"lockdb", "init", "getversion",
+		"dostep", "{v:1 q:'CREATE TABLE tenant_1.t (id INT);' notx:false}",
+		"unlockdb",
+	}
+
+	migs := []*dbump.Migration{
+		{
+			ID:     1,
+			Apply:  "CREATE TABLE ${schema}.t (id INT);",
+			Revert: "DROP TABLE ${schema}.t;",
+		},
+	}
+
+	mm := &tests.MockMigrator{}
+	cfg := dbump.Config{
+		Migrator: mm,
+		Loader:   dbump.NewSliceLoader(migs),
+		Mode:     dbump.ModeApplyAll,
+		Vars:     map[string]string{"schema": "tenant_1"},
+	}
+
+	failIfErr(t, dbump.Run(context.Background(), cfg))
+	mustEqual(t, mm.Log(), wantLog)
+
+	// loaded migrations are not changed.
+	mustEqual(t, migs[0].Apply, "CREATE TABLE ${schema}.t (id INT);")
+
+	cfg.Vars = map[string]string{}
+	failIfOk(t, dbump.Run(context.Background(), cfg))
+}